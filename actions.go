@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// taskActionBtn routes every button of a task card,
+// its data is "<action>:<task id>"
+var taskActionBtn = tb.InlineButton{Unique: "task"}
+
+const (
+	actionStatus        = "status"
+	actionAssign        = "assign"
+	actionDeadline      = "deadline"
+	actionDetails       = "details"
+	actionTitle         = "title"
+	actionDescription   = "description"
+	actionMove          = "move"
+	actionPriority      = "priority"
+	actionLabels        = "labels"
+	actionSubtask       = "subtask"
+	actionChecklist     = "checklist"
+	actionDelete        = "delete"
+	actionDeleteConfirm = "delete_yes"
+	actionDeleteCancel  = "delete_no"
+)
+
+func taskActionButton(text, action string, taskID int) tb.InlineButton {
+	return dataButton(taskActionBtn, text, fmt.Sprintf("%s:%d", action, taskID))
+}
+
+//taskCardText render a task in one line, starting with its key
+func (b Bot) taskCardText(task TaskDB, locale DateLocale) string {
+	card := fmt.Sprintf("*%s* *%s* - *%s* - *%s* - %s", b.taskKey(task),
+		escapeMarkdown(names(task.Assignees)), escapeMarkdown(locale.formatDeadline(task)), escapeMarkdown(task.Title),
+		escapeMarkdown(task.Status))
+	if task.Priority != "" {
+		card += " - " + task.Priority
+	}
+	if len(task.Labels) > 0 {
+		card += " " + escapeMarkdown(labelsText(task.Labels))
+	}
+	if progress := b.progressText(task); progress != "" {
+		card += " - " + progress
+	}
+	if len(b.openBlockers(task)) > 0 {
+		card += " - ⛔ blocked"
+	}
+	return card
+}
+
+//handleTaskAction route the buttons of task cards
+func (b Bot) handleTaskAction(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	action := parts[0]
+	taskID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	permission := permEditTasks
+	switch action {
+	case actionDetails:
+		permission = permViewTasks
+	case actionDelete, actionDeleteConfirm:
+		permission = permDeleteTasks
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permission); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	m := callbackMessage(c)
+	switch action {
+	case actionStatus:
+		b.askStatus(task, m)
+	case actionAssign:
+		if err := b.sessions.Start(m, StateAssignTask, task.ID); err != nil {
+			b.bot.Send(m.Chat, fmt.Sprintf("Cannot assign task: %s", err.Error()))
+			return
+		}
+		b.bot.Send(m.Chat, fmt.Sprintf("Who should do *%s*? @mention an user.", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+	case actionDeadline:
+		if err := b.sessions.Start(m, StateSetDeadline, task.ID); err != nil {
+			b.bot.Send(m.Chat, fmt.Sprintf("Cannot set task deadline: %s", err.Error()))
+			return
+		}
+		b.bot.Send(m.Chat, fmt.Sprintf("When is *%s* due? eg: tomorrow 5pm, or none", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+	case actionTitle:
+		if err := b.sessions.Start(m, StateEditTitle, task.ID); err != nil {
+			b.bot.Send(m.Chat, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+			return
+		}
+		b.bot.Send(m.Chat, fmt.Sprintf("Send the new title of *%s*", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+	case actionDescription:
+		if err := b.sessions.Start(m, StateEditDescription, task.ID); err != nil {
+			b.bot.Send(m.Chat, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+			return
+		}
+		b.bot.Send(m.Chat, fmt.Sprintf("Send the new description of *%s*, or none", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+	case actionDetails:
+		b.showTaskDetail(task, m)
+	case actionMove:
+		b.askProject(task, m)
+	case actionPriority:
+		b.askPriority(task, m)
+	case actionLabels:
+		b.askLabels(task, m)
+	case actionSubtask:
+		b.askSubtask(task, m)
+	case actionChecklist:
+		b.showChecklist(task, m)
+	case actionDelete:
+		b.bot.Send(m.Chat, fmt.Sprintf("Delete *%s*?", escapeMarkdown(task.Title)), &tb.SendOptions{
+			ParseMode: tb.ModeMarkdown,
+			ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{
+				taskActionButton("Yes, delete", actionDeleteConfirm, task.ID),
+				taskActionButton("No", actionDeleteCancel, task.ID),
+			}}},
+		})
+	case actionDeleteConfirm:
+		message, options, err := b.trashTask(task, m)
+		if err != nil {
+			b.bot.Edit(c.Message, fmt.Sprintf("Cannot delete task: %s", err.Error()))
+			return
+		}
+		b.bot.Edit(c.Message, message, options)
+	case actionDeleteCancel:
+		b.bot.Delete(c.Message)
+	}
+}
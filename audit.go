@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// actions of the activity log
+const (
+	activityCreated  = "created"
+	activityChanged  = "changed"
+	activityDeleted  = "deleted"
+	activityRestored = "restored"
+)
+
+// fields of a task the activity log follows
+const (
+	fieldTitle       = "title"
+	fieldDescription = "description"
+	fieldStatus      = "status"
+	fieldAssignee    = "assignee"
+	fieldWatchers    = "watchers"
+	fieldPriority    = "priority"
+	fieldLabels      = "labels"
+	fieldChecklist   = "checklist"
+	fieldBlockedBy   = "blocked by"
+	fieldDeadline    = "deadline"
+	fieldRecurrence  = "repeat"
+	fieldProject     = "project"
+)
+
+// historyChanges is how many of the latest changes /history shows
+const historyChanges = 20
+
+// activityChanges is how many changes /activity shows, at most maxActivityChanges
+const (
+	activityChanges    = 10
+	maxActivityChanges = 50
+)
+
+//Actor is who changes a task, and from which chat
+type Actor struct {
+	ID     int
+	Name   string
+	ChatID int64
+}
+
+//actorOf is the sender of a message in its chat
+func actorOf(m *tb.Message) Actor {
+	return Actor{ID: m.Sender.ID, Name: displayName(m.Sender), ChatID: m.Chat.ID}
+}
+
+//botActor is the bot changing tasks by itself, eg: when a task recurs
+func botActor(chatID int64) Actor {
+	return Actor{Name: "bot", ChatID: chatID}
+}
+
+//activityTime write a date of the activity log, empty for no date
+func activityTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+//taskChanges list the fields that differ between two versions of a task
+func taskChanges(old, task TaskDB) []Activity {
+	changes := []Activity{}
+	compare := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, Activity{Action: activityChanged, Field: field, Old: before, New: after})
+		}
+	}
+	compare(fieldTitle, old.Title, task.Title)
+	compare(fieldDescription, old.Description, task.Description)
+	compare(fieldStatus, old.Status, task.Status)
+	compare(fieldAssignee, names(old.Assignees), names(task.Assignees))
+	compare(fieldWatchers, names(old.Watchers), names(task.Watchers))
+	compare(fieldPriority, old.Priority, task.Priority)
+	compare(fieldLabels, labelsText(old.Labels), labelsText(task.Labels))
+	compare(fieldChecklist, checklistSummary(old.Checklist), checklistSummary(task.Checklist))
+	compare(fieldBlockedBy, blockersText(old.BlockedBy), blockersText(task.BlockedBy))
+	compare(fieldDeadline, activityTime(old.Deadline), activityTime(task.Deadline))
+	compare(fieldRecurrence, old.Recurrence, task.Recurrence)
+	return changes
+}
+
+//activityValue render a value of the activity log
+func activityValue(field, value string, locale DateLocale) string {
+	if value == "" {
+		return "none"
+	}
+	if field == fieldDeadline {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return locale.Format(t)
+		}
+	}
+	return escapeMarkdown(shorten(value, maxButtonTitle))
+}
+
+//activityText tell what a change did, eg: "status todo → done"
+func activityText(activity Activity, locale DateLocale) string {
+	switch activity.Action {
+	case activityCreated:
+		return "created it"
+	case activityDeleted:
+		return "deleted it"
+	case activityRestored:
+		return "restored it"
+	}
+	return fmt.Sprintf("%s %s → %s", activity.Field,
+		activityValue(activity.Field, activity.Old, locale), activityValue(activity.Field, activity.New, locale))
+}
+
+//activityTaskKey return the key of the task of a change, even when it was deleted
+func (b Bot) activityTaskKey(taskID int) string {
+	if task, err := b.storage.GetTask(taskID); err == nil {
+		return b.taskKey(task)
+	}
+	if trashed, err := b.storage.GetTrashedTask(taskID); err == nil {
+		return b.taskKey(trashed.Task)
+	}
+	return "#" + strconv.Itoa(taskID)
+}
+
+//handleHistory show who changed what in the replied task, or the task given by its id or key
+func (b Bot) handleHistory(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get task history: %s", err.Error()))
+		return
+	}
+	activities, err := b.storage.GetTaskActivity(task.ID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get task history: %s", err.Error()))
+		return
+	}
+	locale := b.locale(m.Chat.ID)
+	message := fmt.Sprintf("*%s* *%s* history:\n", b.taskKey(task), task.Title)
+	if len(activities) == 0 {
+		message += "No change recorded yet\n"
+	}
+	if len(activities) > historyChanges {
+		message += fmt.Sprintf("… %d earlier changes\n", len(activities)-historyChanges)
+		activities = activities[len(activities)-historyChanges:]
+	}
+	for _, activity := range activities {
+		message += fmt.Sprintf("_%s_ %s %s\n", locale.Format(activity.At),
+			escapeMarkdown(activity.Actor), activityText(activity, locale))
+	}
+	b.replyAbout(task, m, message, tb.ModeMarkdown)
+}
+
+//handleActivity show the latest changes of the tasks of the default project,
+//eg: /activity 20
+func (b Bot) handleActivity(m *tb.Message) {
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, err := b.storage.GetChatProject(m.Chat.ID, defaultProject.ProjectID)
+	if err != nil {
+		b.bot.Reply(m, "Set a default project first with /set_default_project")
+		return
+	}
+	limit := activityChanges
+	if payload := strings.TrimSpace(m.Payload); payload != "" {
+		limit, err = strconv.Atoi(payload)
+		if err != nil || limit <= 0 {
+			b.bot.Reply(m, "Give how many changes to show, eg: /activity 20")
+			return
+		}
+		if limit > maxActivityChanges {
+			limit = maxActivityChanges
+		}
+	}
+	activities, err := b.storage.GetProjectActivity(project.ID, limit)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get project activity: %s", err.Error()))
+		return
+	}
+	locale := b.locale(m.Chat.ID)
+	message := fmt.Sprintf("Latest changes in *%s*:\n", project.Title)
+	if len(activities) == 0 {
+		message += "No change recorded yet\n"
+	}
+	keys := map[int]string{}
+	for _, activity := range activities {
+		if _, ok := keys[activity.TaskID]; !ok {
+			keys[activity.TaskID] = b.activityTaskKey(activity.TaskID)
+		}
+		message += fmt.Sprintf("_%s_ %s %s: %s\n", locale.Format(activity.At), escapeMarkdown(activity.Actor),
+			keys[activity.TaskID], activityText(activity, locale))
+	}
+	b.bot.Reply(m, message, tb.ModeMarkdown)
+}
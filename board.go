@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// board buttons, boardCardBtn data is a task id, boardMoveBtn data is
+// "<task id>:<state>" and the others have the id of the project of the board
+var (
+	boardCardBtn    = tb.InlineButton{Unique: "board_card"}
+	boardMoveBtn    = tb.InlineButton{Unique: "board_move"}
+	boardRefreshBtn = tb.InlineButton{Unique: "board_refresh"}
+	boardImageBtn   = tb.InlineButton{Unique: "board_image"}
+)
+
+// boardColumnWidth is how many characters of a card a column shows
+const boardColumnWidth = 16
+
+// boardTextWidth is the width of the columns of the text board, narrower to fit phones
+const boardTextWidth = 14
+
+// boardCards is how many cards a column shows, boardButtons how many cards get a button
+const (
+	boardCards   = 8
+	boardButtons = 20
+)
+
+//boardCard is a task as a board shows it
+type boardCard struct {
+	ID       int
+	Key      string
+	Title    string
+	Priority string
+}
+
+//boardColumn is a workflow state and its tasks, the most urgent first
+type boardColumn struct {
+	State string
+	Cards []boardCard
+	// More is how many tasks did not fit in the column
+	More int
+}
+
+func (c boardColumn) header() string {
+	return fmt.Sprintf("%s (%d)", strings.ToUpper(c.State), len(c.Cards)+c.More)
+}
+
+func (c boardColumn) moreText() string {
+	return fmt.Sprintf("+%d more", c.More)
+}
+
+//lines render the cards of the column for the text board, eg: "OPS-3 Fix login"
+func (c boardColumn) lines() []string {
+	lines := []string{}
+	for _, card := range c.Cards {
+		lines = append(lines, card.Key+" "+card.Title)
+	}
+	if c.More > 0 {
+		lines = append(lines, c.moreText())
+	}
+	return lines
+}
+
+//boardColumns group the tasks of a project by the states of its workflow
+//Tasks in a status the workflow does not have anymore go in its first state.
+func (b Bot) boardColumns(projectID int) ([]boardColumn, error) {
+	tasks, err := b.storage.FindTasks(q.Eq("ProjectID", projectID))
+	if err != nil {
+		return nil, err
+	}
+	sortTasks(tasks, sortPriority)
+	workflow := workflowOf(b.storage, projectID)
+	columns := []boardColumn{}
+	index := map[string]int{}
+	for i, state := range workflow.States {
+		columns = append(columns, boardColumn{State: state})
+		index[state] = i
+	}
+	for _, task := range tasks {
+		i, ok := index[workflow.Normalize(task.Status)]
+		if !ok {
+			i = index[workflow.Initial()]
+		}
+		if len(columns[i].Cards) == boardCards {
+			columns[i].More++
+			continue
+		}
+		columns[i].Cards = append(columns[i].Cards, boardCard{
+			ID:       task.ID,
+			Key:      b.taskKey(task),
+			Title:    task.Title,
+			Priority: task.Priority,
+		})
+	}
+	return columns, nil
+}
+
+//pad cut or fill a text with spaces to a width in characters
+func pad(text string, width int) string {
+	text = shorten(text, width)
+	return text + strings.Repeat(" ", width-utf8.RuneCountInString(text))
+}
+
+//boardText render a board as a monospaced table, a column per state
+func boardText(title string, columns []boardColumn) string {
+	rows := 0
+	headers := []string{}
+	rules := []string{}
+	for _, column := range columns {
+		headers = append(headers, pad(column.header(), boardTextWidth))
+		rules = append(rules, strings.Repeat("─", boardTextWidth))
+		if n := len(column.lines()); n > rows {
+			rows = n
+		}
+	}
+	table := []string{strings.Join(headers, " │ "), strings.Join(rules, "─┼─")}
+	for row := 0; row < rows; row++ {
+		cells := []string{}
+		for _, column := range columns {
+			cell := ""
+			if lines := column.lines(); row < len(lines) {
+				cell = lines[row]
+			}
+			cells = append(cells, pad(cell, boardTextWidth))
+		}
+		table = append(table, strings.TrimRight(strings.Join(cells, " │ "), " "))
+	}
+	// backticks would end the code block
+	text := strings.Replace(strings.Join(table, "\n"), "`", "'", -1)
+	return fmt.Sprintf("*%s* board\n```\n%s\n```", title, text)
+}
+
+//boardKeyboard is a button per card opening its moves, and the board actions
+func boardKeyboard(projectID int, columns []boardColumn) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	buttons := 0
+	for _, column := range columns {
+		for _, card := range column.Cards {
+			if buttons == boardButtons {
+				break
+			}
+			buttons++
+			row = append(row, dataButton(boardCardBtn, shorten(card.Key+" "+card.Title, maxButtonTitle), strconv.Itoa(card.ID)))
+			if len(row) == 2 {
+				keys = append(keys, row)
+				row = []tb.InlineButton{}
+			}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	project := strconv.Itoa(projectID)
+	return append(keys, []tb.InlineButton{
+		dataButton(boardRefreshBtn, "🔄 Refresh", project),
+		dataButton(boardImageBtn, "🖼 Image", project),
+	})
+}
+
+//boardCardKeyboard is a button per state a card can move to, and back to the board
+func boardCardKeyboard(task TaskDB, workflow Workflow) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	for _, state := range workflow.Next(task.Status) {
+		row = append(row, dataButton(boardMoveBtn, "→ "+state, fmt.Sprintf("%d:%s", task.ID, state)))
+		if len(row) == 2 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	return append(keys, []tb.InlineButton{dataButton(boardRefreshBtn, "« Board", strconv.Itoa(task.ProjectID))})
+}
+
+//showBoard send the board of a project as text, or edit edit into it when set
+func (b Bot) showBoard(project ProjectDB, chat *tb.Chat, edit *tb.Message) {
+	columns, err := b.boardColumns(project.ID)
+	if err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot get board: %s", err.Error()))
+		return
+	}
+	options := &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: boardKeyboard(project.ID, columns)},
+	}
+	if edit != nil {
+		b.bot.Edit(edit, boardText(project.Title, columns), options)
+		return
+	}
+	b.bot.Send(chat, boardText(project.Title, columns), options)
+}
+
+//sendBoardImage draw the board of a project and send it as a photo
+func (b Bot) sendBoardImage(project ProjectDB, chat *tb.Chat) {
+	columns, err := b.boardColumns(project.ID)
+	if err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot get board: %s", err.Error()))
+		return
+	}
+	// telebot uploads files from disk only
+	file, err := ioutil.TempFile("", "board-*.png")
+	if err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot draw board: %s", err.Error()))
+		return
+	}
+	defer os.Remove(file.Name())
+	err = drawBoard(file, project.Title, columns)
+	file.Close()
+	if err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot draw board: %s", err.Error()))
+		return
+	}
+	photo := &tb.Photo{File: tb.FromDisk(file.Name()), Caption: fmt.Sprintf("%s board", project.Title)}
+	if _, err := b.bot.Send(chat, photo); err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot send board: %s", err.Error()))
+	}
+}
+
+//handleBoard show the tasks of the current project by state, as text with
+//buttons moving them or as an image, eg: /board image
+func (b Bot) handleBoard(m *tb.Message) {
+	defaultProject, err := b.storage.GetDefaultProject(m.Chat.ID)
+	if err != nil {
+		b.bot.Reply(m, "Set a default project first with /set_default_project")
+		return
+	}
+	project, err := b.storage.GetChatProject(m.Chat.ID, defaultProject.ProjectID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get board: %s", err.Error()))
+		return
+	}
+	if strings.TrimSpace(m.Payload) == "image" {
+		b.sendBoardImage(project, m.Chat)
+		return
+	}
+	b.showBoard(project, m.Chat, nil)
+}
+
+//boardProject get the project of a board button
+func (b Bot) boardProject(c *tb.Callback) (ProjectDB, bool) {
+	projectID, _ := strconv.Atoi(c.Data)
+	project, err := b.storage.GetChatProject(c.Message.Chat.ID, projectID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This project does not belong to this chat anymore"})
+		return project, false
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	return project, true
+}
+
+//handleBoardRefresh show the board again in the same message
+func (b Bot) handleBoardRefresh(c *tb.Callback) {
+	if project, ok := b.boardProject(c); ok {
+		b.showBoard(project, c.Message.Chat, c.Message)
+	}
+}
+
+//handleBoardImage send the board as an image
+func (b Bot) handleBoardImage(c *tb.Callback) {
+	if project, ok := b.boardProject(c); ok {
+		b.sendBoardImage(project, c.Message.Chat)
+	}
+}
+
+//handleBoardCard show the states a card can move to in place of the board buttons
+func (b Bot) handleBoardCard(c *tb.Callback) {
+	taskID, _ := strconv.Atoi(c.Data)
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	workflow := workflowOf(b.storage, task.ProjectID)
+	b.bot.Edit(c.Message, fmt.Sprintf("Move *%s* *%s* from *%s* to:", b.taskKey(task), task.Title,
+		workflow.Normalize(task.Status)), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: boardCardKeyboard(task, workflow)},
+	})
+}
+
+//handleBoardMove move a card to the state picked and show the board again
+func (b Bot) handleBoardMove(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setStatus(task.ID, parts[1], callbackMessage(c))
+	project, err := b.storage.GetChatProject(c.Message.Chat.ID, task.ProjectID)
+	if err == nil {
+		b.showBoard(project, c.Message.Chat, c.Message)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// The board image is drawn with a 5x7 bitmap font of the printable ASCII
+// characters, so it needs no font files. Each glyph is 5 columns, the lowest
+// bit is the top row and the 8th row holds descenders. Other characters are
+// drawn as "?".
+var glyphs = [95][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, {0x00, 0x00, 0x5F, 0x00, 0x00}, {0x00, 0x07, 0x00, 0x07, 0x00}, // space ! "
+	{0x14, 0x7F, 0x14, 0x7F, 0x14}, {0x24, 0x2A, 0x7F, 0x2A, 0x12}, {0x23, 0x13, 0x08, 0x64, 0x62}, // # $ %
+	{0x36, 0x49, 0x56, 0x20, 0x50}, {0x00, 0x08, 0x07, 0x03, 0x00}, {0x00, 0x1C, 0x22, 0x41, 0x00}, // & ' (
+	{0x00, 0x41, 0x22, 0x1C, 0x00}, {0x2A, 0x1C, 0x7F, 0x1C, 0x2A}, {0x08, 0x08, 0x3E, 0x08, 0x08}, // ) * +
+	{0x00, 0x80, 0x70, 0x30, 0x00}, {0x08, 0x08, 0x08, 0x08, 0x08}, {0x00, 0x00, 0x60, 0x60, 0x00}, // , - .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, {0x3E, 0x51, 0x49, 0x45, 0x3E}, {0x00, 0x42, 0x7F, 0x40, 0x00}, // / 0 1
+	{0x72, 0x49, 0x49, 0x49, 0x46}, {0x21, 0x41, 0x49, 0x4D, 0x33}, {0x18, 0x14, 0x12, 0x7F, 0x10}, // 2 3 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, {0x3C, 0x4A, 0x49, 0x49, 0x31}, {0x41, 0x21, 0x11, 0x09, 0x07}, // 5 6 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, {0x46, 0x49, 0x49, 0x29, 0x1E}, {0x00, 0x00, 0x14, 0x00, 0x00}, // 8 9 :
+	{0x00, 0x40, 0x34, 0x00, 0x00}, {0x00, 0x08, 0x14, 0x22, 0x41}, {0x14, 0x14, 0x14, 0x14, 0x14}, // ; < =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, {0x02, 0x01, 0x59, 0x09, 0x06}, {0x3E, 0x41, 0x5D, 0x59, 0x4E}, // > ? @
+	{0x7C, 0x12, 0x11, 0x12, 0x7C}, {0x7F, 0x49, 0x49, 0x49, 0x36}, {0x3E, 0x41, 0x41, 0x41, 0x22}, // A B C
+	{0x7F, 0x41, 0x41, 0x41, 0x3E}, {0x7F, 0x49, 0x49, 0x49, 0x41}, {0x7F, 0x09, 0x09, 0x09, 0x01}, // D E F
+	{0x3E, 0x41, 0x41, 0x51, 0x73}, {0x7F, 0x08, 0x08, 0x08, 0x7F}, {0x00, 0x41, 0x7F, 0x41, 0x00}, // G H I
+	{0x20, 0x40, 0x41, 0x3F, 0x01}, {0x7F, 0x08, 0x14, 0x22, 0x41}, {0x7F, 0x40, 0x40, 0x40, 0x40}, // J K L
+	{0x7F, 0x02, 0x1C, 0x02, 0x7F}, {0x7F, 0x04, 0x08, 0x10, 0x7F}, {0x3E, 0x41, 0x41, 0x41, 0x3E}, // M N O
+	{0x7F, 0x09, 0x09, 0x09, 0x06}, {0x3E, 0x41, 0x51, 0x21, 0x5E}, {0x7F, 0x09, 0x19, 0x29, 0x46}, // P Q R
+	{0x26, 0x49, 0x49, 0x49, 0x32}, {0x03, 0x01, 0x7F, 0x01, 0x03}, {0x3F, 0x40, 0x40, 0x40, 0x3F}, // S T U
+	{0x1F, 0x20, 0x40, 0x20, 0x1F}, {0x3F, 0x40, 0x38, 0x40, 0x3F}, {0x63, 0x14, 0x08, 0x14, 0x63}, // V W X
+	{0x03, 0x04, 0x78, 0x04, 0x03}, {0x61, 0x59, 0x49, 0x4D, 0x43}, {0x00, 0x7F, 0x41, 0x41, 0x41}, // Y Z [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, {0x00, 0x41, 0x41, 0x41, 0x7F}, {0x04, 0x02, 0x01, 0x02, 0x04}, // \ ] ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, {0x00, 0x03, 0x07, 0x08, 0x00}, {0x20, 0x54, 0x54, 0x78, 0x40}, // _ ` a
+	{0x7F, 0x28, 0x44, 0x44, 0x38}, {0x38, 0x44, 0x44, 0x44, 0x28}, {0x38, 0x44, 0x44, 0x28, 0x7F}, // b c d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, {0x00, 0x08, 0x7E, 0x09, 0x02}, {0x18, 0xA4, 0xA4, 0x9C, 0x78}, // e f g
+	{0x7F, 0x08, 0x04, 0x04, 0x78}, {0x00, 0x44, 0x7D, 0x40, 0x00}, {0x20, 0x40, 0x40, 0x3D, 0x00}, // h i j
+	{0x7F, 0x10, 0x28, 0x44, 0x00}, {0x00, 0x41, 0x7F, 0x40, 0x00}, {0x7C, 0x04, 0x78, 0x04, 0x78}, // k l m
+	{0x7C, 0x08, 0x04, 0x04, 0x78}, {0x38, 0x44, 0x44, 0x44, 0x38}, {0xFC, 0x18, 0x24, 0x24, 0x18}, // n o p
+	{0x18, 0x24, 0x24, 0x18, 0xFC}, {0x7C, 0x08, 0x04, 0x04, 0x08}, {0x48, 0x54, 0x54, 0x54, 0x24}, // q r s
+	{0x04, 0x04, 0x3F, 0x44, 0x24}, {0x3C, 0x40, 0x40, 0x20, 0x7C}, {0x1C, 0x20, 0x40, 0x20, 0x1C}, // t u v
+	{0x3C, 0x40, 0x30, 0x40, 0x3C}, {0x44, 0x28, 0x10, 0x28, 0x44}, {0x4C, 0x90, 0x90, 0x90, 0x7C}, // w x y
+	{0x44, 0x64, 0x54, 0x4C, 0x44}, {0x00, 0x08, 0x36, 0x41, 0x00}, {0x00, 0x00, 0x77, 0x00, 0x00}, // z { |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, {0x02, 0x01, 0x02, 0x04, 0x02}, // } ~
+}
+
+// sizes of the board image in pixels
+const (
+	fontScale    = 2
+	glyphWidth   = 6 * fontScale // 5 columns and a space
+	lineHeight   = 10 * fontScale
+	boardPadding = 8
+	cardPadding  = 6
+)
+
+var (
+	boardBackground = color.RGBA{0xEB, 0xEC, 0xF0, 0xFF}
+	columnColor     = color.RGBA{0xF7, 0xF8, 0xFA, 0xFF}
+	headerColor     = color.RGBA{0x17, 0x2B, 0x4D, 0xFF}
+	cardColor       = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	textColor       = color.RGBA{0x17, 0x2B, 0x4D, 0xFF}
+	mutedColor      = color.RGBA{0x6B, 0x77, 0x8C, 0xFF}
+	// priorityColors mark the left edge of cards, P0 first
+	priorityColors = []color.RGBA{
+		{0xDE, 0x35, 0x0B, 0xFF},
+		{0xFF, 0x99, 0x1F, 0xFF},
+		{0x00, 0x65, 0xFF, 0xFF},
+		{0x97, 0xA0, 0xAF, 0xFF},
+	}
+)
+
+// latinFolds pairs accented latin letters, eg: the vietnamese ones, with the
+// ASCII letter drawn for them, the font only has ASCII glyphs
+const latinFolds = "ÀAÁAÂAÃAÄAÅAÇCÈEÉEÊEËEÌIÍIÎIÏIÑNÒOÓOÔOÕOÖOÙUÚUÛUÜUÝYàaáaâaãa" +
+	"äaåaçcèeéeêeëeìiíiîiïiñnòoóoôoõoöoùuúuûuüuýyÿyĀAāaĂAăaĄAąaĆC" +
+	"ćcĈCĉcĊCċcČCčcĎDďdĒEēeĔEĕeĖEėeĘEęeĚEěeĜGĝgĞGğgĠGġgĢGģgĤHĥhĨI" +
+	"ĩiĪIīiĬIĭiĮIįiİIĴJĵjĶKķkĹLĺlĻLļlĽLľlŃNńnŅNņnŇNňnŌOōoŎOŏoŐOőo" +
+	"ŔRŕrŖRŗrŘRřrŚSśsŜSŝsŞSşsŠSšsŢTţtŤTťtŨUũuŪUūuŬUŭuŮUůuŰUűuŲUųu" +
+	"ŴWŵwŶYŷyŸYŹZźzŻZżzŽZžzƠOơoƯUưuǍAǎaǏIǐiǑOǒoǓUǔuǕUǖuǗUǘuǙUǚuǛU" +
+	"ǜuǞAǟaǠAǡaǦGǧgǨKǩkǪOǫoǬOǭoǰjǴGǵgǸNǹnǺAǻaȀAȁaȂAȃaȄEȅeȆEȇeȈIȉi" +
+	"ȊIȋiȌOȍoȎOȏoȐRȑrȒRȓrȔUȕuȖUȗuȘSșsȚTțtȞHȟhȦAȧaȨEȩeȪOȫoȬOȭoȮOȯo" +
+	"ȰOȱoȲYȳyḀAḁaḂBḃbḄBḅbḆBḇbḈCḉcḊDḋdḌDḍdḎDḏdḐDḑdḒDḓdḔEḕeḖEḗeḘEḙe" +
+	"ḚEḛeḜEḝeḞFḟfḠGḡgḢHḣhḤHḥhḦHḧhḨHḩhḪHḫhḬIḭiḮIḯiḰKḱkḲKḳkḴKḵkḶLḷl" +
+	"ḸLḹlḺLḻlḼLḽlḾMḿmṀMṁmṂMṃmṄNṅnṆNṇnṈNṉnṊNṋnṌOṍoṎOṏoṐOṑoṒOṓoṔPṕp" +
+	"ṖPṗpṘRṙrṚRṛrṜRṝrṞRṟrṠSṡsṢSṣsṤSṥsṦSṧsṨSṩsṪTṫtṬTṭtṮTṯtṰTṱtṲUṳu" +
+	"ṴUṵuṶUṷuṸUṹuṺUṻuṼVṽvṾVṿvẀWẁwẂWẃwẄWẅwẆWẇwẈWẉwẊXẋxẌXẍxẎYẏyẐZẑz" +
+	"ẒZẓzẔZẕzẖhẗtẘwẙyẠAạaẢAảaẤAấaẦAầaẨAẩaẪAẫaẬAậaẮAắaẰAằaẲAẳaẴAẵa" +
+	"ẶAặaẸEẹeẺEẻeẼEẽeẾEếeỀEềeỂEểeỄEễeỆEệeỈIỉiỊIịiỌOọoỎOỏoỐOốoỒOồo" +
+	"ỔOổoỖOỗoỘOộoỚOớoỜOờoỞOởoỠOỡoỢOợoỤUụuỦUủuỨUứuỪUừuỬUửuỮUữuỰUựu" +
+	"ỲYỳyỴYỵyỶYỷyỸYỹyĐDđdØOøoŁLłlßsÆAæaŒOœoĦHħhıi"
+
+// asciiFolds maps each letter of latinFolds to its ASCII letter
+var asciiFolds = func() map[rune]rune {
+	folds := map[rune]rune{}
+	letters := []rune(latinFolds)
+	for i := 0; i+1 < len(letters); i += 2 {
+		folds[letters[i]] = letters[i+1]
+	}
+	return folds
+}()
+
+//clip cut a text to a width in characters, ending with ".." when it is cut
+//since the font has no ellipsis
+func clip(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-2]) + ".."
+}
+
+//drawText write a line of text with its top left corner at x, y
+//Accented latin letters are drawn without their accents, other characters
+//outside ASCII, eg: CJK or emoji, are drawn as "?".
+func drawText(img draw.Image, x, y int, text string, c color.Color) {
+	for _, r := range text {
+		if folded, ok := asciiFolds[r]; ok {
+			r = folded
+		}
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		for column, bits := range glyphs[r-' '] {
+			for row := 0; row < 8; row++ {
+				if bits&(1<<uint(row)) == 0 {
+					continue
+				}
+				dot := image.Rect(x+column*fontScale, y+row*fontScale, x+(column+1)*fontScale, y+(row+1)*fontScale)
+				draw.Draw(img, dot, image.NewUniform(c), image.Point{}, draw.Src)
+			}
+		}
+		x += glyphWidth
+	}
+}
+
+func fillRect(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+//drawBoard draw the columns of a board side by side as a PNG image
+func drawBoard(w io.Writer, title string, columns []boardColumn) error {
+	columnWidth := boardColumnWidth*glyphWidth + 2*cardPadding
+	cardHeight := 2*lineHeight + 2*cardPadding
+	rows := 0
+	for _, column := range columns {
+		if n := len(column.lines()); n > rows {
+			rows = n
+		}
+	}
+	width := boardPadding + len(columns)*(columnWidth+boardPadding)
+	if min := len(title)*glyphWidth + 2*boardPadding; width < min {
+		width = min
+	}
+	top := boardPadding + lineHeight + boardPadding
+	height := top + lineHeight + 2*cardPadding + rows*(cardHeight+cardPadding) + boardPadding
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), boardBackground)
+	drawText(img, boardPadding, boardPadding, title, headerColor)
+	for i, column := range columns {
+		x := boardPadding + i*(columnWidth+boardPadding)
+		fillRect(img, image.Rect(x, top, x+columnWidth, height-boardPadding), columnColor)
+		drawText(img, x+cardPadding, top+cardPadding, clip(column.header(), boardColumnWidth), headerColor)
+		y := top + lineHeight + 2*cardPadding
+		for _, card := range column.Cards {
+			fillRect(img, image.Rect(x+cardPadding, y, x+columnWidth-cardPadding, y+cardHeight), cardColor)
+			if rank := priorityRank(card.Priority); rank < len(priorityColors) {
+				fillRect(img, image.Rect(x+cardPadding, y, x+cardPadding+4, y+cardHeight), priorityColors[rank])
+			}
+			drawText(img, x+2*cardPadding, y+cardPadding, card.Key, mutedColor)
+			drawText(img, x+2*cardPadding, y+cardPadding+lineHeight, clip(card.Title, boardColumnWidth-1), textColor)
+			y += cardHeight + cardPadding
+		}
+		if column.More > 0 {
+			drawText(img, x+cardPadding, y, column.moreText(), mutedColor)
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 )
 
 //TaskStorage db object
@@ -13,21 +19,90 @@ type TaskStorage struct {
 
 //TaskDB db object
 type TaskDB struct {
-	ID          int `storm:"id,increment"`
-	ProjectID   int `storm:"index"`
-	Title       string
-	Deadline    string
-	Status      string `storm:"index"` // init,doing,done
-	Assigned    string `storm:"index"`
+	ID        int   `storm:"id,increment"`
+	ProjectID int   `storm:"index"`
+	ChatID    int64 `storm:"index"`
+	Title     string
+	Deadline  time.Time `storm:"index"`
+	// DeadlineText keeps an old free text deadline that could not be parsed
+	DeadlineText string
+	Status       string `storm:"index"` // a state of the project workflow
+	// Assignees do the task, Watchers are only told about its changes
+	Assignees   []Person
+	Watchers    []Person
 	Description string
+	// Priority is one of priorities, P0 the most urgent, or empty
+	Priority string `storm:"index"`
+	// Labels are lower case and without "#", storm cannot index each of them
+	// so hasLabel scans the tasks
+	Labels []string
+	// ParentID is the task this one is a subtask of
+	ParentID  int `storm:"index"`
+	Checklist []ChecklistItem
+	// BlockedBy are the tasks to be done before this one can start, not
+	// indexed as storm cannot index each of them
+	BlockedBy []int
+	// Recurrence is the rule repeating this task, see recurrence.go
+	Recurrence string
+	// NextTaskID is the task created when this one recurred
+	NextTaskID int
+	// Number counts the tasks of the project, it makes the task key like OPS-42
+	Number int `storm:"index"`
+	// CreatorID is the telegram user who created the task, Creator their name then
+	CreatorID int
+	Creator   string
+	// History is every status the task went through, oldest first
+	History []StatusChange
+}
+
+//Person is a telegram user a task is assigned to or watched by
+//ID is 0 until the bot knows them, Name is their username or first name.
+type Person struct {
+	ID   int
+	Name string
+}
+
+//StatusChange is a move of a task to a status
+type StatusChange struct {
+	Status string
+	At     time.Time
+	By     string
+}
+
+//Attachment db object
+//A file or photo sent in reply to a task.
+type Attachment struct {
+	ID       int `storm:"id,increment"`
+	TaskID   int `storm:"index"`
+	FileID   string
+	FileName string
+	// Photo tells whether the file is sent back as a photo or as a document
+	Photo bool
+	By    string
+	At    time.Time
 }
 
 //ProjectDB db object
+//A project belongs to the chat it was created in, other chats see it
+//only after joining it with its share code.
 type ProjectDB struct {
 	ID      int `storm:"id,increment"`
 	Title   string
 	Creator string `storm:"index"`
-	Status  string `storm:"index"`
+	// CreatorID is the telegram user who created the project and owns it,
+	// usernames can change hands so ownership goes by id
+	CreatorID int `storm:"index"`
+	// Status is projectActive or projectArchived, empty for projects created before it
+	Status string `storm:"index"`
+	ChatID int64  `storm:"index"`
+	// ShareCode lets other chats join the project, empty when it is not shared
+	ShareCode string `storm:"index"`
+	// Key starts the keys of the tasks of the project, eg: OPS in OPS-42
+	Key string `storm:"index"`
+	// LastNumber is the number of the latest task of the project
+	LastNumber int
+	// Workflow is empty for projects using the default workflow
+	Workflow Workflow
 }
 
 //DefaultProject db object
@@ -37,6 +112,119 @@ type DefaultProject struct {
 	ProjectID int
 }
 
+//ProjectShare db object
+//A chat other than the owner that joined a project.
+type ProjectShare struct {
+	ID        int   `storm:"id,increment"`
+	ProjectID int   `storm:"index"`
+	ChatID    int64 `storm:"index"`
+}
+
+//RoleDB db object
+//ProjectID is 0 for a role in the whole chat, ChatID is 0 for a role in a project.
+type RoleDB struct {
+	ID        int   `storm:"id,increment"`
+	ChatID    int64 `storm:"index"`
+	ProjectID int   `storm:"index"`
+	UserID    int   `storm:"index"`
+	Role      string
+}
+
+//ChatSettings db object
+//Empty values fall back to the bot defaults from the config file.
+type ChatSettings struct {
+	ChatID    int64 `storm:"id"`
+	TimeZone  string
+	DateOrder string // dmy or mdy
+	Digest    Digest
+	// RolesSeeded is set once the admins of the group were given roles
+	RolesSeeded bool
+}
+
+//Digest is when a chat gets its digest of tasks, in the time zone of the chat
+//It is off when it has no weekday.
+type Digest struct {
+	Hour     int
+	Minute   int
+	Weekdays []time.Weekday
+	// Private digests list the tasks of the user of a private chat
+	Private bool
+	SentAt  time.Time
+}
+
+//UserDB db object
+//Users are recorded as they talk to the bot so they can be found by username.
+type UserDB struct {
+	ID        int    `storm:"id"`
+	Username  string `storm:"index"`
+	FirstName string
+}
+
+//Comment db object
+//A message of the discussion of a task.
+type Comment struct {
+	ID       int `storm:"id,increment"`
+	TaskID   int `storm:"index"`
+	AuthorID int
+	Author   string
+	Text     string
+	At       time.Time
+}
+
+//DiscussionThread db object
+//The message of a chat the bot edits to show the discussion of a task.
+type DiscussionThread struct {
+	ID        int   `storm:"id,increment"`
+	TaskID    int   `storm:"index"`
+	ChatID    int64 `storm:"index"`
+	MessageID int
+}
+
+//TaskMessage db object
+//A message the bot sent about tasks, so replies to it find them.
+type TaskMessage struct {
+	ID        int   `storm:"id,increment"`
+	ChatID    int64 `storm:"index"`
+	MessageID int   `storm:"index"`
+	TaskIDs   []int
+}
+
+//Activity db object
+//A change of a task by someone, the activity log is only appended to.
+type Activity struct {
+	ID        int   `storm:"id,increment"`
+	TaskID    int   `storm:"index"`
+	ProjectID int   `storm:"index"`
+	ChatID    int64 // the chat the change was made from
+	ActorID   int
+	Actor     string
+	At        time.Time
+	Action    string // created, changed, deleted or restored
+	// Field, Old and New tell what changed, dates are in RFC 3339
+	Field string
+	Old   string
+	New   string
+}
+
+//TrashedTask db object
+//A deleted task, kept until the trash retention ends so it can be restored.
+type TrashedTask struct {
+	ID        int   `storm:"id"` // the id of the task
+	ProjectID int   `storm:"index"`
+	ChatID    int64 `storm:"index"` // the chat it was deleted from
+	Task      TaskDB
+	DeletedBy string
+	DeletedAt time.Time `storm:"index"`
+}
+
+//SavedFilter db object
+//A task list filter too long for the callback data of a button, the buttons
+//carry its id instead.
+type SavedFilter struct {
+	ID   int    `storm:"id,increment"`
+	Data string `storm:"unique"`
+}
+
 //PinMessage db object
 type PinMessage struct {
 	ID      int `storm:"id,increment"`
@@ -58,59 +246,317 @@ func NewStorage() (*TaskStorage, error) {
 }
 
 //StoreTask save new task to db
-func (t *TaskStorage) StoreTask(task Task, projectID int) error {
+func (t *TaskStorage) StoreTask(task Task, projectID int, chatID int64) (TaskDB, error) {
 	data := TaskDB{
-		ProjectID: projectID,
-		Title:     task.Title,
-		Deadline:  task.Deadline,
-		Assigned:  task.Assigned,
-		Status:    task.Status,
+		ProjectID:   projectID,
+		ChatID:      chatID,
+		Title:       task.Title,
+		Deadline:    task.Deadline,
+		Assignees:   task.Assignees,
+		Priority:    task.Priority,
+		Labels:      task.Labels,
+		ParentID:    task.ParentID,
+		Status:      task.Status,
+		Description: task.Description,
+		CreatorID:   task.CreatorID,
+		Creator:     task.Creator,
+		History:     []StatusChange{{Status: task.Status, At: time.Now(), By: task.Creator}},
+	}
+	err := t.CreateTask(&data, Actor{ID: task.CreatorID, Name: task.Creator, ChatID: chatID})
+	return data, err
+}
+
+//recordActivities append changes of a task to the activity log
+func recordActivities(tx storm.Node, task TaskDB, actor Actor, at time.Time, activities ...Activity) error {
+	for _, activity := range activities {
+		activity.TaskID = task.ID
+		activity.ProjectID = task.ProjectID
+		activity.ChatID = actor.ChatID
+		activity.ActorID = actor.ID
+		activity.Actor = actor.Name
+		activity.At = at
+		if err := tx.Save(&activity); err != nil {
+			log.Printf("Cannot record activity of task %d: %s", task.ID, err.Error())
+			return err
+		}
 	}
-	err := t.db.Save(&data)
+	return nil
+}
+
+//CreateTask save a new task record, filling its ID and its number in the project
+func (t *TaskStorage) CreateTask(task *TaskDB, actor Actor) error {
+	tx, err := t.db.Begin(true)
 	if err != nil {
 		log.Printf("Cannot save task: %s", err.Error())
+		return err
 	}
-	return err
+	defer tx.Rollback()
+	var project ProjectDB
+	if err := tx.One("ID", task.ProjectID, &project); err == nil {
+		project.LastNumber++
+		task.Number = project.LastNumber
+		if err := tx.Save(&project); err != nil {
+			log.Printf("Cannot number task: %s", err.Error())
+			return err
+		}
+	}
+	if err := tx.Save(task); err != nil {
+		log.Printf("Cannot save task: %s", err.Error())
+		return err
+	}
+	created := Activity{Action: activityCreated, New: task.Title}
+	if err := recordActivities(tx, *task, actor, time.Now(), created); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 //UpdateTask update a task
 //A task can be update assignee, deadline, status, etc.
-func (t *TaskStorage) UpdateTask(task TaskDB) error {
-	err := t.db.Update(&task)
+//The whole record is written so fields can also be cleared.
+//Every field that changed is added to the activity log.
+func (t *TaskStorage) UpdateTask(task TaskDB, actor Actor) error {
+	tx, err := t.db.Begin(true)
 	if err != nil {
 		log.Printf("Cannot update task %s: %s", task.Title, err.Error())
+		return err
 	}
-	return err
+	defer tx.Rollback()
+	var old TaskDB
+	if err := tx.One("ID", task.ID, &old); err != nil {
+		log.Printf("Cannot update task %s: %s", task.Title, err.Error())
+		return err
+	}
+	if err := tx.Save(&task); err != nil {
+		log.Printf("Cannot update task %s: %s", task.Title, err.Error())
+		return err
+	}
+	if err := recordActivities(tx, task, actor, time.Now(), taskChanges(old, task)...); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//GetAllTasks return all task available
-func (t *TaskStorage) GetAllTasks() ([]TaskDB, error) {
-	var tasks []TaskDB
-	err := t.db.All(&tasks)
+//MoveTask move a task to another project, where it gets a new number
+func (t *TaskStorage) MoveTask(task *TaskDB, projectID int, actor Actor) error {
+	tx, err := t.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get all tasks: %s", err.Error())
+		log.Printf("Cannot move task %d: %s", task.ID, err.Error())
+		return err
+	}
+	defer tx.Rollback()
+	var old TaskDB
+	if err := tx.One("ID", task.ID, &old); err != nil {
+		log.Printf("Cannot move task %d: %s", task.ID, err.Error())
+		return err
 	}
-	return tasks, err
+	var from, project ProjectDB
+	tx.One("ID", old.ProjectID, &from)
+	if err := tx.One("ID", projectID, &project); err != nil {
+		log.Printf("Cannot get project %d: %s", projectID, err.Error())
+		return err
+	}
+	project.LastNumber++
+	task.ProjectID = project.ID
+	task.Number = project.LastNumber
+	if err := tx.Save(&project); err != nil {
+		log.Printf("Cannot number task: %s", err.Error())
+		return err
+	}
+	if err := tx.Save(task); err != nil {
+		log.Printf("Cannot move task %d: %s", task.ID, err.Error())
+		return err
+	}
+	moved := Activity{Action: activityChanged, Field: fieldProject, Old: from.Title, New: project.Title}
+	changes := append(taskChanges(old, *task), moved)
+	if err := recordActivities(tx, *task, actor, time.Now(), changes...); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//GetTaskByStatus get task by its status
-func (t *TaskStorage) GetTaskByStatus(status string) ([]TaskDB, error) {
-	var tasks []TaskDB
-	err := t.db.Find("Status", status, &tasks)
+//TrashTask delete a task into the trash, its subtasks are left without parent
+func (t *TaskStorage) TrashTask(task TaskDB, actor Actor, at time.Time) (TrashedTask, error) {
+	trashed := TrashedTask{
+		ID:        task.ID,
+		ProjectID: task.ProjectID,
+		ChatID:    actor.ChatID,
+		Task:      task,
+		DeletedBy: actor.Name,
+		DeletedAt: at,
+	}
+	tx, err := t.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get task with status %s: %s", status, err.Error())
+		log.Printf("Cannot delete task %d: %s", task.ID, err.Error())
+		return trashed, err
+	}
+	defer tx.Rollback()
+	if err := tx.Save(&trashed); err != nil {
+		log.Printf("Cannot delete task %d: %s", task.ID, err.Error())
+		return trashed, err
 	}
-	return tasks, err
+	if err := tx.DeleteStruct(&task); err != nil {
+		log.Printf("Cannot delete task %d: %s", task.ID, err.Error())
+		return trashed, err
+	}
+	var children []TaskDB
+	if err := tx.Find("ParentID", task.ID, &children); err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get subtasks of task %d: %s", task.ID, err.Error())
+		return trashed, err
+	}
+	for _, child := range children {
+		child.ParentID = 0
+		if err := tx.Save(&child); err != nil {
+			log.Printf("Cannot detach subtask %d: %s", child.ID, err.Error())
+			return trashed, err
+		}
+	}
+	deleted := Activity{Action: activityDeleted, Old: task.Title}
+	if err := recordActivities(tx, task, actor, at, deleted); err != nil {
+		return trashed, err
+	}
+	return trashed, tx.Commit()
+}
+
+//RestoreTask bring a task back from the trash with its id and number
+func (t *TaskStorage) RestoreTask(trashed TrashedTask, actor Actor) (TaskDB, error) {
+	task := trashed.Task
+	tx, err := t.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot restore task %d: %s", trashed.ID, err.Error())
+		return task, err
+	}
+	defer tx.Rollback()
+	if err := tx.Save(&task); err != nil {
+		log.Printf("Cannot restore task %d: %s", trashed.ID, err.Error())
+		return task, err
+	}
+	if err := tx.DeleteStruct(&trashed); err != nil {
+		log.Printf("Cannot restore task %d: %s", trashed.ID, err.Error())
+		return task, err
+	}
+	restored := Activity{Action: activityRestored, New: task.Title}
+	if err := recordActivities(tx, task, actor, time.Now(), restored); err != nil {
+		return task, err
+	}
+	return task, tx.Commit()
 }
 
-//GetTaskByAssignee get task by its assignee
-func (t *TaskStorage) GetTaskByAssignee(telegramID string) ([]TaskDB, error) {
+//GetTaskActivity get the changes of a task, oldest first
+func (t *TaskStorage) GetTaskActivity(taskID int) ([]Activity, error) {
+	var activities []Activity
+	err := t.db.Select(q.Eq("TaskID", taskID)).OrderBy("ID").Find(&activities)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get activity of task %d: %s", taskID, err.Error())
+		return nil, err
+	}
+	return activities, nil
+}
+
+//GetProjectActivity get the latest changes of the tasks of a project, latest first
+func (t *TaskStorage) GetProjectActivity(projectID int, limit int) ([]Activity, error) {
+	var activities []Activity
+	err := t.db.Select(q.Eq("ProjectID", projectID)).OrderBy("ID").Reverse().Limit(limit).Find(&activities)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get activity of project %d: %s", projectID, err.Error())
+		return nil, err
+	}
+	return activities, nil
+}
+
+//GetTrashedTask get a deleted task by its id
+func (t *TaskStorage) GetTrashedTask(taskID int) (TrashedTask, error) {
+	var trashed TrashedTask
+	err := t.db.One("ID", taskID, &trashed)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get deleted task %d: %s", taskID, err.Error())
+	}
+	return trashed, err
+}
+
+//GetTrash get the deleted tasks matching every matcher, latest first
+func (t *TaskStorage) GetTrash(matchers ...q.Matcher) ([]TrashedTask, error) {
+	var trash []TrashedTask
+	err := t.db.Select(matchers...).OrderBy("DeletedAt").Reverse().Find(&trash)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get deleted tasks: %s", err.Error())
+		return nil, err
+	}
+	return trash, nil
+}
+
+//PurgeTrash remove for good the tasks deleted before a time,
+//with their comments and attachments
+func (t *TaskStorage) PurgeTrash(before time.Time) (int, error) {
+	trash, err := t.GetTrash(q.Lt("DeletedAt", before))
+	if err != nil {
+		return 0, err
+	}
+	for _, trashed := range trash {
+		for _, record := range []interface{}{&Comment{}, &Attachment{}, &DiscussionThread{}} {
+			err := t.db.Select(q.Eq("TaskID", trashed.ID)).Delete(record)
+			if err != nil && err != storm.ErrNotFound {
+				log.Printf("Cannot purge task %d: %s", trashed.ID, err.Error())
+				return 0, err
+			}
+		}
+		if err := t.db.DeleteStruct(&trashed); err != nil {
+			log.Printf("Cannot purge task %d: %s", trashed.ID, err.Error())
+			return 0, err
+		}
+	}
+	return len(trash), nil
+}
+
+//FindTasks get the tasks matching every matcher, ordered by id
+func (t *TaskStorage) FindTasks(matchers ...q.Matcher) ([]TaskDB, error) {
 	var tasks []TaskDB
-	err := t.db.Find("Assigned", telegramID, &tasks)
+	err := t.db.Select(matchers...).OrderBy("ID").Find(&tasks)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot find tasks: %s", err.Error())
+		return nil, err
+	}
+	return tasks, nil
+}
+
+//GetTaskAssignees return the distinct assignees of the tasks matching every matcher
+func (t *TaskStorage) GetTaskAssignees(matchers ...q.Matcher) ([]string, error) {
+	tasks, err := t.FindTasks(matchers...)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		for _, assignee := range task.Assignees {
+			if !seen[assignee.Name] {
+				seen[assignee.Name] = true
+				result = append(result, assignee.Name)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+//GetTaskLabels return the distinct labels of the tasks matching every matcher
+func (t *TaskStorage) GetTaskLabels(matchers ...q.Matcher) ([]string, error) {
+	tasks, err := t.FindTasks(matchers...)
 	if err != nil {
-		log.Printf("Cannot get task by assignee %s: %s", telegramID, err.Error())
+		return nil, err
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		for _, label := range task.Labels {
+			if !seen[label] {
+				seen[label] = true
+				result = append(result, label)
+			}
+		}
 	}
-	return tasks, err
+	sort.Strings(result)
+	return result, nil
 }
 
 //GetTask by task ID
@@ -123,27 +569,244 @@ func (t *TaskStorage) GetTask(taskID int) (TaskDB, error) {
 	return task, err
 }
 
-//StoreProject store a project
-func (t *TaskStorage) StoreProject(project Project) error {
+//GetChatTask get a task by its id if its project is visible in the chat
+func (t *TaskStorage) GetChatTask(chatID int64, taskID int) (TaskDB, error) {
+	task, err := t.GetTask(taskID)
+	if err != nil {
+		return task, err
+	}
+	if _, err := t.GetChatProject(chatID, task.ProjectID); err != nil {
+		return TaskDB{}, storm.ErrNotFound
+	}
+	return task, nil
+}
+
+//GetTaskByNumber get the task of a project having a number
+func (t *TaskStorage) GetTaskByNumber(projectID int, number int) (TaskDB, error) {
+	var task TaskDB
+	err := t.db.Select(q.Eq("ProjectID", projectID), q.Eq("Number", number)).First(&task)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get task %d of project %d: %s", number, projectID, err.Error())
+	}
+	return task, err
+}
+
+//StoreTaskMessage remember the tasks a sent message is about
+func (t *TaskStorage) StoreTaskMessage(chatID int64, messageID int, taskIDs []int) error {
+	var message TaskMessage
+	err := t.db.Select(q.Eq("ChatID", chatID), q.Eq("MessageID", messageID)).First(&message)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get message %d: %s", messageID, err.Error())
+		return err
+	}
+	message.ChatID = chatID
+	message.MessageID = messageID
+	message.TaskIDs = taskIDs
+	err = t.db.Save(&message)
+	if err != nil {
+		log.Printf("Cannot save tasks of message %d: %s", messageID, err.Error())
+	}
+	return err
+}
+
+//GetTaskMessage get the tasks a sent message is about
+func (t *TaskStorage) GetTaskMessage(chatID int64, messageID int) (TaskMessage, error) {
+	var message TaskMessage
+	err := t.db.Select(q.Eq("ChatID", chatID), q.Eq("MessageID", messageID)).First(&message)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get tasks of message %d: %s", messageID, err.Error())
+	}
+	return message, err
+}
+
+//StoreProject store a project owned by a chat
+func (t *TaskStorage) StoreProject(project Project, chatID int64) (ProjectDB, error) {
+	key, err := t.uniqueProjectKey(projectKeyOf(project.Title))
+	if err != nil {
+		return ProjectDB{}, err
+	}
 	data := ProjectDB{
-		Title:   project.Title,
-		Creator: project.Creator,
+		Title:     project.Title,
+		Creator:   project.Creator,
+		CreatorID: project.CreatorID,
+		Status:    projectActive,
+		ChatID:    chatID,
+		Key:       key,
 	}
-	err := t.db.Save(&data)
+	err = t.db.Save(&data)
 	if err != nil {
 		log.Printf("Cannot save project: %s", err.Error())
 	}
+	return data, err
+}
+
+//GetChatProjects get the projects a chat owns or joined
+func (t *TaskStorage) GetChatProjects(chatID int64) ([]ProjectDB, error) {
+	var shares []ProjectShare
+	err := t.db.Find("ChatID", chatID, &shares)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get projects shared with chat %d: %s", chatID, err.Error())
+		return nil, err
+	}
+	shared := []int{}
+	for _, share := range shares {
+		shared = append(shared, share.ProjectID)
+	}
+	var projects []ProjectDB
+	err = t.db.Select(q.Or(q.Eq("ChatID", chatID), q.In("ID", shared))).OrderBy("ID").Find(&projects)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get projects of chat %d: %s", chatID, err.Error())
+		return nil, err
+	}
+	return projects, nil
+}
+
+//GetChatProject get a project by its id if the chat can see it
+func (t *TaskStorage) GetChatProject(chatID int64, projectID int) (ProjectDB, error) {
+	project, err := t.GetProject(projectID)
+	if err != nil || project.ChatID == chatID {
+		return project, err
+	}
+	var share ProjectShare
+	err = t.db.Select(q.Eq("ProjectID", projectID), q.Eq("ChatID", chatID)).First(&share)
+	if err != nil {
+		return ProjectDB{}, err
+	}
+	return project, nil
+}
+
+//GetProjectByKey get the project whose tasks have a key, eg: OPS
+func (t *TaskStorage) GetProjectByKey(key string) (ProjectDB, error) {
+	var project ProjectDB
+	err := t.db.One("Key", key, &project)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get project by key %s: %s", key, err.Error())
+	}
+	return project, err
+}
+
+//uniqueProjectKey return the key, followed by a number when a project already has it
+func (t *TaskStorage) uniqueProjectKey(key string) (string, error) {
+	candidate := key
+	for i := 2; ; i++ {
+		_, err := t.GetProjectByKey(candidate)
+		if err == storm.ErrNotFound {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%d", key, i)
+	}
+}
+
+//GetProjectByShareCode get the project shared with a code
+func (t *TaskStorage) GetProjectByShareCode(code string) (ProjectDB, error) {
+	var project ProjectDB
+	err := t.db.One("ShareCode", code, &project)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get project by share code: %s", err.Error())
+	}
+	return project, err
+}
+
+//StoreProjectShare let a chat see a project, once
+func (t *TaskStorage) StoreProjectShare(projectID int, chatID int64) error {
+	var share ProjectShare
+	err := t.db.Select(q.Eq("ProjectID", projectID), q.Eq("ChatID", chatID)).First(&share)
+	if err == nil {
+		return nil
+	}
+	share = ProjectShare{
+		ProjectID: projectID,
+		ChatID:    chatID,
+	}
+	err = t.db.Save(&share)
+	if err != nil {
+		log.Printf("Cannot share project %d with chat %d: %s", projectID, chatID, err.Error())
+	}
 	return err
 }
 
-//GetAllProjects get all projects
-func (t *TaskStorage) GetAllProjects() ([]ProjectDB, error) {
-	var result []ProjectDB
-	err := t.db.All(&result)
+//DeleteProjectShares stop sharing a project with every chat that joined it
+//Those chats lose it as their default project.
+func (t *TaskStorage) DeleteProjectShares(project ProjectDB) error {
+	err := t.db.Select(q.Eq("ProjectID", project.ID)).Delete(&ProjectShare{})
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot delete shares of project %d: %s", project.ID, err.Error())
+		return err
+	}
+	err = t.db.Select(q.Eq("ProjectID", project.ID), q.Not(q.Eq("ChatID", project.ChatID))).Delete(&DefaultProject{})
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot reset default project %d: %s", project.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+//DeleteProject delete a project with its tasks, deleted tasks, their comments,
+//attachments, discussions, reminders and activity, its shares, roles and the
+//chats using it as default. It returns how many tasks were deleted.
+func (t *TaskStorage) DeleteProject(project ProjectDB) (int, error) {
+	tx, err := t.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get projects: %s", err.Error())
+		log.Printf("Cannot delete project %d: %s", project.ID, err.Error())
+		return 0, err
+	}
+	defer tx.Rollback()
+	var tasks []TaskDB
+	if err := tx.Find("ProjectID", project.ID, &tasks); err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot delete tasks of project %d: %s", project.ID, err.Error())
+		return 0, err
+	}
+	var trash []TrashedTask
+	if err := tx.Find("ProjectID", project.ID, &trash); err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot delete deleted tasks of project %d: %s", project.ID, err.Error())
+		return 0, err
 	}
-	return result, err
+	taskIDs := []int{}
+	for _, task := range tasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+	for _, trashed := range trash {
+		taskIDs = append(taskIDs, trashed.ID)
+	}
+	records := []struct {
+		matcher q.Matcher
+		record  interface{}
+	}{
+		{q.In("TaskID", taskIDs), &Comment{}},
+		{q.In("TaskID", taskIDs), &Attachment{}},
+		{q.In("TaskID", taskIDs), &DiscussionThread{}},
+		{q.In("TaskID", taskIDs), &Reminder{}},
+		{q.Eq("ProjectID", project.ID), &Activity{}},
+		{q.Eq("ProjectID", project.ID), &TaskDB{}},
+		{q.Eq("ProjectID", project.ID), &TrashedTask{}},
+		{q.Eq("ProjectID", project.ID), &ProjectShare{}},
+		{q.Eq("ProjectID", project.ID), &RoleDB{}},
+		{q.Eq("ProjectID", project.ID), &DefaultProject{}},
+	}
+	for _, r := range records {
+		if err := tx.Select(r.matcher).Delete(r.record); err != nil && err != storm.ErrNotFound {
+			log.Printf("Cannot delete project %d: %s", project.ID, err.Error())
+			return 0, err
+		}
+	}
+	if err := tx.DeleteStruct(&project); err != nil {
+		log.Printf("Cannot delete project %d: %s", project.ID, err.Error())
+		return 0, err
+	}
+	return len(tasks), tx.Commit()
+}
+
+//DeleteDefaultProject leave a chat without default project
+func (t *TaskStorage) DeleteDefaultProject(chatID int64) error {
+	err := t.db.Select(q.Eq("ChatID", chatID)).Delete(&DefaultProject{})
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot reset default project of chat %d: %s", chatID, err.Error())
+		return err
+	}
+	return nil
 }
 
 //GetProject get a project by its id
@@ -156,6 +819,15 @@ func (t *TaskStorage) GetProject(projectID int) (ProjectDB, error) {
 	return project, err
 }
 
+//UpdateProject update a project
+func (t *TaskStorage) UpdateProject(project ProjectDB) error {
+	err := t.db.Save(&project)
+	if err != nil {
+		log.Printf("Cannot update project %s: %s", project.Title, err.Error())
+	}
+	return err
+}
+
 //StoreDefaultProject save default project
 func (t *TaskStorage) StoreDefaultProject(chatID int64, projectID int) error {
 	defaultProject, err := t.GetDefaultProject(chatID)
@@ -234,3 +906,672 @@ func (t *TaskStorage) GetPinMessage(chatID int64) (string, error) {
 	}
 	return pinMessage.Message, nil
 }
+
+//StoreSession save a dialog session
+func (t *TaskStorage) StoreSession(session Session) error {
+	err := t.db.Save(&session)
+	if err != nil {
+		log.Printf("Cannot save session %s: %s", session.Key, err.Error())
+	}
+	return err
+}
+
+//GetSession get a dialog session by its key
+func (t *TaskStorage) GetSession(key string) (Session, error) {
+	var session Session
+	err := t.db.One("Key", key, &session)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get session %s: %s", key, err.Error())
+	}
+	return session, err
+}
+
+//DeleteSession remove a dialog session
+func (t *TaskStorage) DeleteSession(key string) error {
+	err := t.db.DeleteStruct(&Session{Key: key})
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot delete session %s: %s", key, err.Error())
+	}
+	return err
+}
+
+//DeleteSessionsBefore remove sessions last updated before a time
+func (t *TaskStorage) DeleteSessionsBefore(before time.Time) (int, error) {
+	query := t.db.Select(q.Lt("UpdatedAt", before))
+	count, err := query.Count(&Session{})
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	err = query.Delete(&Session{})
+	if err != nil {
+		log.Printf("Cannot delete expired sessions: %s", err.Error())
+		return 0, err
+	}
+	return count, nil
+}
+
+//GetRecentAssignees return the latest distinct assignees of a project
+func (t *TaskStorage) GetRecentAssignees(projectID int, limit int) ([]string, error) {
+	var tasks []TaskDB
+	err := t.db.Select(q.Eq("ProjectID", projectID)).OrderBy("ID").Reverse().Find(&tasks)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get recent assignees of project %d: %s", projectID, err.Error())
+		return nil, err
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		for _, assignee := range task.Assignees {
+			if seen[assignee.Name] {
+				continue
+			}
+			seen[assignee.Name] = true
+			result = append(result, assignee.Name)
+			if len(result) == limit {
+				return result, nil
+			}
+		}
+	}
+	return result, nil
+}
+
+//GetChatSettings get the settings of a chat
+func (t *TaskStorage) GetChatSettings(chatID int64) (ChatSettings, error) {
+	settings := ChatSettings{ChatID: chatID}
+	err := t.db.One("ChatID", chatID, &settings)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get settings of chat %d: %s", chatID, err.Error())
+		return settings, err
+	}
+	return settings, nil
+}
+
+//StoreChatSettings save the settings of a chat
+func (t *TaskStorage) StoreChatSettings(settings ChatSettings) error {
+	err := t.db.Save(&settings)
+	if err != nil {
+		log.Printf("Cannot save settings of chat %d: %s", settings.ChatID, err.Error())
+	}
+	return err
+}
+
+//MarkDigestSent record when the digest of a chat was sent, keeping the rest
+//of its settings as they are now
+func (t *TaskStorage) MarkDigestSent(chatID int64, at time.Time) error {
+	tx, err := t.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot save digest of chat %d: %s", chatID, err.Error())
+		return err
+	}
+	defer tx.Rollback()
+	var settings ChatSettings
+	if err := tx.One("ChatID", chatID, &settings); err != nil {
+		log.Printf("Cannot save digest of chat %d: %s", chatID, err.Error())
+		return err
+	}
+	settings.Digest.SentAt = at
+	if err := tx.Save(&settings); err != nil {
+		log.Printf("Cannot save digest of chat %d: %s", chatID, err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+//GetDigestChats get the settings of the chats having a digest
+func (t *TaskStorage) GetDigestChats() ([]ChatSettings, error) {
+	var all []ChatSettings
+	err := t.db.All(&all)
+	if err != nil {
+		log.Printf("Cannot get chat settings: %s", err.Error())
+		return nil, err
+	}
+	chats := []ChatSettings{}
+	for _, settings := range all {
+		if len(settings.Digest.Weekdays) > 0 {
+			chats = append(chats, settings)
+		}
+	}
+	return chats, nil
+}
+
+//legacyTaskDB is the part of TaskDB that changed since deadlines were free text
+type legacyTaskDB struct {
+	ID       int
+	Deadline json.RawMessage
+}
+
+//MigrateDeadlines convert free text deadlines into times, as of the creation
+//of the task when it is known. Deadlines that cannot be parsed are cleared
+//and kept in DeadlineText. Tasks already migrated are left alone so it is safe to run on every start.
+func (t *TaskStorage) MigrateDeadlines(locale DateLocale) (int, int, error) {
+	records, err := t.db.Select().Bucket("TaskDB").Raw()
+	if err != nil {
+		log.Printf("Cannot read tasks to migrate: %s", err.Error())
+		return 0, 0, err
+	}
+	migrated, unparsed := 0, 0
+	for _, record := range records {
+		var legacy legacyTaskDB
+		var text string
+		if json.Unmarshal(record, &legacy) != nil || json.Unmarshal(legacy.Deadline, &text) != nil {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, text); err == nil {
+			continue
+		}
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(record, &fields); err != nil {
+			continue
+		}
+		delete(fields, "Deadline")
+		data, _ := json.Marshal(fields)
+		var task TaskDB
+		if err := json.Unmarshal(data, &task); err != nil {
+			log.Printf("Cannot migrate task %d: %s", legacy.ID, err.Error())
+			continue
+		}
+		// saving drops the fields TaskDB no longer has, so the legacy assignee
+		// is migrated now rather than by MigrateAssignees
+		t.legacyAssignees(record, &task)
+		if text != "" {
+			// the text was written when the task was created, a date that has
+			// passed since is overdue rather than due next year
+			taskLocale := locale
+			taskLocale.KeepPast = true
+			if created := task.createdAt(); !created.IsZero() {
+				taskLocale.Now = func() time.Time { return created }
+			}
+			deadline, err := taskLocale.Parse(text)
+			if err != nil {
+				task.DeadlineText = text
+				unparsed++
+			} else {
+				task.Deadline = deadline
+			}
+		}
+		if err := t.db.Save(&task); err != nil {
+			log.Printf("Cannot migrate task %d: %s", task.ID, err.Error())
+			return migrated, unparsed, err
+		}
+		migrated++
+	}
+	return migrated, unparsed, nil
+}
+
+//StoreUser save or refresh a telegram user
+func (t *TaskStorage) StoreUser(user UserDB) error {
+	var saved UserDB
+	err := t.db.One("ID", user.ID, &saved)
+	if err == nil && saved == user {
+		return nil
+	}
+	err = t.db.Save(&user)
+	if err != nil {
+		log.Printf("Cannot save user %d: %s", user.ID, err.Error())
+		return err
+	}
+	if user.Username != "" && user.Username != saved.Username {
+		_, err = t.linkAssignee(user)
+	}
+	return err
+}
+
+//linkAssignee give the id of a user to the tasks assigned to or watched by
+//their username before the bot knew them
+func (t *TaskStorage) linkAssignee(user UserDB) (int, error) {
+	named := Person{Name: user.Username}
+	var tasks []TaskDB
+	err := t.db.Select(q.Or(hasPerson("Assignees", named), hasPerson("Watchers", named))).Find(&tasks)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get tasks of @%s: %s", user.Username, err.Error())
+		return 0, err
+	}
+	linked := 0
+	for _, task := range tasks {
+		changed := false
+		for _, people := range [][]Person{task.Assignees, task.Watchers} {
+			for i := range people {
+				if people[i].ID == 0 && people[i].is(named) {
+					people[i].ID = user.ID
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := t.db.Save(&task); err != nil {
+			log.Printf("Cannot link task %d to user %d: %s", task.ID, user.ID, err.Error())
+			return linked, err
+		}
+		linked++
+	}
+	return linked, nil
+}
+
+//legacyAssigneeTaskDB is the part of TaskDB that changed since tasks had one assignee
+type legacyAssigneeTaskDB struct {
+	ID         int
+	Assigned   string
+	AssigneeID int
+}
+
+//legacyAssignees give a task without assignees the single assignee of its
+//legacy record, if it had one
+func (t *TaskStorage) legacyAssignees(record []byte, task *TaskDB) {
+	var legacy legacyAssigneeTaskDB
+	if json.Unmarshal(record, &legacy) != nil || len(task.Assignees) > 0 {
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(legacy.Assigned), "@")
+	if name == "" {
+		return
+	}
+	assignee := Person{ID: legacy.AssigneeID, Name: name}
+	if user, err := t.GetUserByUsername(assignee.Name); assignee.ID == 0 && err == nil {
+		assignee.ID = user.ID
+	}
+	task.Assignees = []Person{assignee}
+}
+
+//MigrateAssignees turn the single assignee of tasks into their list of
+//assignees, with the id of the user when the bot knows them and without the
+//"@" some usernames were stored with. It is safe to run again.
+func (t *TaskStorage) MigrateAssignees() (int, error) {
+	records, err := t.db.Select().Bucket("TaskDB").Raw()
+	if err != nil {
+		log.Printf("Cannot read tasks to migrate: %s", err.Error())
+		return 0, err
+	}
+	migrated := 0
+	for _, record := range records {
+		var legacy legacyAssigneeTaskDB
+		if json.Unmarshal(record, &legacy) != nil || legacy.Assigned == "" {
+			continue
+		}
+		var task TaskDB
+		if err := json.Unmarshal(record, &task); err != nil {
+			log.Printf("Cannot migrate task %d: %s", legacy.ID, err.Error())
+			continue
+		}
+		t.legacyAssignees(record, &task)
+		// saving drops the old fields
+		if err := t.db.Save(&task); err != nil {
+			log.Printf("Cannot migrate assignee of task %d: %s", task.ID, err.Error())
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+//GetUserByUsername get a telegram user by its username
+func (t *TaskStorage) GetUserByUsername(username string) (UserDB, error) {
+	var user UserDB
+	err := t.db.One("Username", username, &user)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get user %s: %s", username, err.Error())
+	}
+	return user, err
+}
+
+//GetUser get a telegram user by its id
+func (t *TaskStorage) GetUser(userID int) (UserDB, error) {
+	var user UserDB
+	err := t.db.One("ID", userID, &user)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get user %d: %s", userID, err.Error())
+	}
+	return user, err
+}
+
+//GetRole get the role of a user in a chat or a project,
+//an empty role when none was given
+func (t *TaskStorage) GetRole(chatID int64, projectID int, userID int) (RoleDB, error) {
+	var role RoleDB
+	err := t.db.Select(q.Eq("ChatID", chatID), q.Eq("ProjectID", projectID), q.Eq("UserID", userID)).First(&role)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get role of user %d: %s", userID, err.Error())
+		return role, err
+	}
+	return role, nil
+}
+
+//StoreRole give a role to a user in a chat or a project
+func (t *TaskStorage) StoreRole(chatID int64, projectID int, userID int, name string) error {
+	role, err := t.GetRole(chatID, projectID, userID)
+	if err != nil {
+		return err
+	}
+	role.ChatID = chatID
+	role.ProjectID = projectID
+	role.UserID = userID
+	role.Role = name
+	err = t.db.Save(&role)
+	if err != nil {
+		log.Printf("Cannot save role of user %d: %s", userID, err.Error())
+	}
+	return err
+}
+
+//GetRoles get the roles given in a chat or a project
+func (t *TaskStorage) GetRoles(chatID int64, projectID int) ([]RoleDB, error) {
+	var roles []RoleDB
+	err := t.db.Select(q.Eq("ChatID", chatID), q.Eq("ProjectID", projectID)).OrderBy("ID").Find(&roles)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get roles: %s", err.Error())
+		return nil, err
+	}
+	return roles, nil
+}
+
+//GetChatsByDefaultProject get the chats using a project as default
+func (t *TaskStorage) GetChatsByDefaultProject(projectID int) ([]int64, error) {
+	var defaults []DefaultProject
+	err := t.db.Select(q.Eq("ProjectID", projectID)).Find(&defaults)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get chats of project %d: %s", projectID, err.Error())
+		return nil, err
+	}
+	chats := []int64{}
+	for _, defaultProject := range defaults {
+		chats = append(chats, defaultProject.ChatID)
+	}
+	return chats, nil
+}
+
+//StoreAttachment save a file attached to a task
+func (t *TaskStorage) StoreAttachment(attachment Attachment) error {
+	err := t.db.Save(&attachment)
+	if err != nil {
+		log.Printf("Cannot save attachment of task %d: %s", attachment.TaskID, err.Error())
+	}
+	return err
+}
+
+//GetAttachments get the files attached to a task
+func (t *TaskStorage) GetAttachments(taskID int) ([]Attachment, error) {
+	var attachments []Attachment
+	err := t.db.Find("TaskID", taskID, &attachments)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get attachments of task %d: %s", taskID, err.Error())
+		return nil, err
+	}
+	return attachments, nil
+}
+
+//GetAttachment get an attachment by its id
+func (t *TaskStorage) GetAttachment(attachmentID int) (Attachment, error) {
+	var attachment Attachment
+	err := t.db.One("ID", attachmentID, &attachment)
+	if err != nil {
+		log.Printf("Cannot get attachment %d: %s", attachmentID, err.Error())
+	}
+	return attachment, err
+}
+
+//StoreComment add a comment to the discussion of a task
+func (t *TaskStorage) StoreComment(comment Comment) error {
+	err := t.db.Save(&comment)
+	if err != nil {
+		log.Printf("Cannot save comment of task %d: %s", comment.TaskID, err.Error())
+	}
+	return err
+}
+
+//GetComments get the discussion of a task, oldest first
+func (t *TaskStorage) GetComments(taskID int) ([]Comment, error) {
+	var comments []Comment
+	err := t.db.Select(q.Eq("TaskID", taskID)).OrderBy("ID").Find(&comments)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get comments of task %d: %s", taskID, err.Error())
+		return nil, err
+	}
+	return comments, nil
+}
+
+//GetDiscussionThread get the thread message of a task in a chat
+func (t *TaskStorage) GetDiscussionThread(taskID int, chatID int64) (DiscussionThread, error) {
+	var thread DiscussionThread
+	err := t.db.Select(q.Eq("TaskID", taskID), q.Eq("ChatID", chatID)).First(&thread)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get discussion thread of task %d: %s", taskID, err.Error())
+		return thread, err
+	}
+	return thread, nil
+}
+
+//StoreDiscussionThread save the thread message of a task in a chat
+func (t *TaskStorage) StoreDiscussionThread(thread DiscussionThread) error {
+	err := t.db.Save(&thread)
+	if err != nil {
+		log.Printf("Cannot save discussion thread of task %d: %s", thread.TaskID, err.Error())
+	}
+	return err
+}
+
+//GetTasksWithDeadline get every task that has a deadline
+func (t *TaskStorage) GetTasksWithDeadline() ([]TaskDB, error) {
+	var tasks []TaskDB
+	err := t.db.Select(q.Not(q.Eq("Deadline", time.Time{}))).Find(&tasks)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get tasks with deadline: %s", err.Error())
+		return nil, err
+	}
+	return tasks, nil
+}
+
+//StoreReminder save a reminder
+func (t *TaskStorage) StoreReminder(reminder Reminder) error {
+	err := t.db.Save(&reminder)
+	if err != nil {
+		log.Printf("Cannot save reminder of task %d: %s", reminder.TaskID, err.Error())
+	}
+	return err
+}
+
+//GetReminders get every reminder
+func (t *TaskStorage) GetReminders() ([]Reminder, error) {
+	var reminders []Reminder
+	err := t.db.All(&reminders)
+	if err != nil {
+		log.Printf("Cannot get reminders: %s", err.Error())
+	}
+	return reminders, err
+}
+
+//GetReminder get a reminder by its id
+func (t *TaskStorage) GetReminder(reminderID int) (Reminder, error) {
+	var reminder Reminder
+	err := t.db.One("ID", reminderID, &reminder)
+	if err != nil {
+		log.Printf("Cannot get reminder %d: %s", reminderID, err.Error())
+	}
+	return reminder, err
+}
+
+//SaveFilter return the id of an encoded task list filter, saving it the first time
+func (t *TaskStorage) SaveFilter(data string) (int, error) {
+	var filter SavedFilter
+	err := t.db.One("Data", data, &filter)
+	if err == nil {
+		return filter.ID, nil
+	}
+	if err != storm.ErrNotFound {
+		log.Printf("Cannot get filter: %s", err.Error())
+		return 0, err
+	}
+	filter.Data = data
+	if err := t.db.Save(&filter); err != nil {
+		log.Printf("Cannot save filter: %s", err.Error())
+		return 0, err
+	}
+	return filter.ID, nil
+}
+
+//GetFilter get an encoded task list filter by its id
+func (t *TaskStorage) GetFilter(filterID int) (string, error) {
+	var filter SavedFilter
+	err := t.db.One("ID", filterID, &filter)
+	if err != nil {
+		log.Printf("Cannot get filter %d: %s", filterID, err.Error())
+	}
+	return filter.Data, err
+}
+
+//DeleteReminder remove a reminder
+func (t *TaskStorage) DeleteReminder(reminder Reminder) error {
+	err := t.db.DeleteStruct(&reminder)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot delete reminder %d: %s", reminder.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+//GetRecurringTasksDue get recurring tasks whose period ended before a time
+//and that have not recurred yet
+func (t *TaskStorage) GetRecurringTasksDue(before time.Time) ([]TaskDB, error) {
+	var tasks []TaskDB
+	err := t.db.Select(
+		q.Not(q.Eq("Recurrence", "")),
+		q.Eq("NextTaskID", 0),
+		q.Not(q.Eq("Deadline", time.Time{})),
+		q.Lt("Deadline", before),
+	).Find(&tasks)
+	if err != nil && err != storm.ErrNotFound {
+		log.Printf("Cannot get recurring tasks: %s", err.Error())
+		return nil, err
+	}
+	return tasks, nil
+}
+
+//MigrateProjectCreators give projects created before their creator was kept
+//by id the id of their creator, when the bot knows their username, and make
+//them owner of the project. It is safe to run again.
+func (t *TaskStorage) MigrateProjectCreators() (int, error) {
+	var projects []ProjectDB
+	// zero values are not indexed, so the projects are scanned
+	err := t.db.Select(q.Eq("CreatorID", 0), q.Not(q.Eq("Creator", ""))).Find(&projects)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("Cannot get projects to migrate: %s", err.Error())
+		return 0, err
+	}
+	migrated := 0
+	for _, project := range projects {
+		user, err := t.GetUserByUsername(strings.TrimPrefix(project.Creator, "@"))
+		if err != nil {
+			continue
+		}
+		project.CreatorID = user.ID
+		if err := t.UpdateProject(project); err != nil {
+			return migrated, err
+		}
+		if err := t.StoreRole(0, project.ID, user.ID, roleOwner); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+//MigrateProjectChats give an owner chat to projects created before projects
+//belonged to chats. The first chat using a project as default owns it and the
+//other chats using it, or having tasks in it, join it. It is safe to run again.
+func (t *TaskStorage) MigrateProjectChats() (int, error) {
+	var projects []ProjectDB
+	// zero values are not indexed, so the projects are scanned
+	err := t.db.Select(q.Eq("ChatID", int64(0))).Find(&projects)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		log.Printf("Cannot get projects to migrate: %s", err.Error())
+		return 0, err
+	}
+	migrated := 0
+	for _, project := range projects {
+		chats := []int64{}
+		var defaults []DefaultProject
+		err := t.db.Select(q.Eq("ProjectID", project.ID)).OrderBy("ID").Find(&defaults)
+		if err != nil && err != storm.ErrNotFound {
+			return migrated, err
+		}
+		for _, defaultProject := range defaults {
+			chats = append(chats, defaultProject.ChatID)
+		}
+		var tasks []TaskDB
+		err = t.db.Select(q.Eq("ProjectID", project.ID), q.Not(q.Eq("ChatID", int64(0)))).OrderBy("ID").Find(&tasks)
+		if err != nil && err != storm.ErrNotFound {
+			return migrated, err
+		}
+		for _, task := range tasks {
+			chats = append(chats, task.ChatID)
+		}
+		if len(chats) == 0 {
+			continue
+		}
+		project.ChatID = chats[0]
+		if err := t.UpdateProject(project); err != nil {
+			return migrated, err
+		}
+		for _, chatID := range chats[1:] {
+			if chatID == project.ChatID {
+				continue
+			}
+			if err := t.StoreProjectShare(project.ID, chatID); err != nil {
+				return migrated, err
+			}
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+//MigrateTaskKeys give a key to projects and a number to tasks created before
+//task keys existed, tasks are numbered in the order they were created.
+//It is safe to run again.
+func (t *TaskStorage) MigrateTaskKeys() (int, error) {
+	var projects []ProjectDB
+	err := t.db.All(&projects)
+	if err != nil {
+		log.Printf("Cannot get projects to migrate: %s", err.Error())
+		return 0, err
+	}
+	migrated := 0
+	for _, project := range projects {
+		changed := project.Key == ""
+		if changed {
+			project.Key, err = t.uniqueProjectKey(projectKeyOf(project.Title))
+			if err != nil {
+				return migrated, err
+			}
+		}
+		var tasks []TaskDB
+		// zero values are not indexed, so the tasks are scanned
+		err := t.db.Select(q.Eq("ProjectID", project.ID), q.Eq("Number", 0)).OrderBy("ID").Find(&tasks)
+		if err != nil && err != storm.ErrNotFound {
+			return migrated, err
+		}
+		for _, task := range tasks {
+			project.LastNumber++
+			task.Number = project.LastNumber
+			if err := t.db.Save(&task); err != nil {
+				log.Printf("Cannot number task %d: %s", task.ID, err.Error())
+				return migrated, err
+			}
+			changed = true
+			migrated++
+		}
+		if !changed {
+			continue
+		}
+		if err := t.UpdateProject(project); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
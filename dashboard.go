@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// The dashboard is /mine in a private chat with the bot: the open tasks
+// assigned to the user in every chat and project, grouped by due date.
+// Changes made there are told to the chat the task comes from.
+
+var (
+	// dashTaskBtn opens a task of the dashboard, its data is the task id,
+	// or empty to go back to the dashboard
+	dashTaskBtn = tb.InlineButton{Unique: "dash_task"}
+	// dashStatusBtn moves a task of the dashboard, its data is "<task id>|<status>"
+	dashStatusBtn = tb.InlineButton{Unique: "dash_status"}
+)
+
+// dashboardButtons is how many tasks of the dashboard get a button
+const dashboardButtons = 20
+
+// dueGroups are the headings of the dashboard, in the order of dueGroup
+var dueGroups = []string{"⚠️ Overdue", "📅 Today", "Tomorrow", "Next 7 days", "Later", "No deadline"}
+
+//dueGroup tell which heading of the dashboard a deadline is under
+func dueGroup(deadline time.Time, now time.Time) int {
+	if deadline.IsZero() {
+		return 5
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch {
+	case deadline.Before(now):
+		return 0
+	case deadline.Before(today.AddDate(0, 0, 1)):
+		return 1
+	case deadline.Before(today.AddDate(0, 0, 2)):
+		return 2
+	case deadline.Before(today.AddDate(0, 0, 8)):
+		return 3
+	}
+	return 4
+}
+
+//assignedTasks get the open tasks assigned to a user matching every matcher,
+//the ones due first first
+func (b Bot) assignedTasks(userID int, matchers ...q.Matcher) ([]TaskDB, error) {
+	tasks, err := b.storage.FindTasks(append(matchers, hasPerson("Assignees", Person{ID: userID}))...)
+	if err != nil {
+		return nil, err
+	}
+	open := []TaskDB{}
+	for _, task := range tasks {
+		if !isClosed(b.storage, task) {
+			open = append(open, task)
+		}
+	}
+	sort.SliceStable(open, func(i, j int) bool {
+		if open[i].Deadline.IsZero() || open[j].Deadline.IsZero() {
+			return !open[i].Deadline.IsZero()
+		}
+		return open[i].Deadline.Before(open[j].Deadline)
+	})
+	return open, nil
+}
+
+//originChat is the chat a task was created in, where its changes are told
+func (b Bot) originChat(task TaskDB) int64 {
+	if task.ChatID != 0 {
+		return task.ChatID
+	}
+	project, _ := b.storage.GetProject(task.ProjectID)
+	return project.ChatID
+}
+
+//chatOf is the chat to tell about a task changed from a message, the chat of
+//the task when the message comes from a private chat
+func (b Bot) chatOf(task TaskDB, m *tb.Message) *tb.Chat {
+	if m.Chat.Type == tb.ChatPrivate {
+		return &tb.Chat{ID: b.originChat(task)}
+	}
+	return m.Chat
+}
+
+//showDashboard send the dashboard of the sender, or edit edit into it when set
+func (b Bot) showDashboard(m *tb.Message, edit *tb.Message) {
+	tasks, err := b.assignedTasks(m.Sender.ID)
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot get your task list: %s", err.Error()))
+		return
+	}
+	locale := b.locale(m.Chat.ID)
+	now := locale.now()
+	message := fmt.Sprintf("Your tasks, %d open:\n", len(tasks))
+	if len(tasks) == 0 {
+		message = "You have no open task 🎉\n"
+	}
+	projects := map[int]string{}
+	group := -1
+	keys := [][]tb.InlineButton{}
+	for i, task := range tasks {
+		if g := dueGroup(task.Deadline, now); g != group {
+			group = g
+			message += fmt.Sprintf("\n*%s*\n", dueGroups[group])
+		}
+		if _, ok := projects[task.ProjectID]; !ok {
+			project, _ := b.storage.GetProject(task.ProjectID)
+			projects[task.ProjectID] = project.Title
+		}
+		key := b.taskKey(task)
+		message += fmt.Sprintf("%s *%s* - %s - %s\n", key, escapeMarkdown(task.Title),
+			escapeMarkdown(locale.formatDeadline(task)), orNone(escapeMarkdown(projects[task.ProjectID])))
+		if i < dashboardButtons {
+			keys = append(keys, []tb.InlineButton{
+				dataButton(dashTaskBtn, fmt.Sprintf("%s %s", key, shorten(task.Title, maxButtonTitle)), strconv.Itoa(task.ID)),
+			})
+		}
+	}
+	options := &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	}
+	if edit != nil {
+		b.bot.Edit(edit, message, options)
+		return
+	}
+	b.bot.Send(m.Chat, message, options)
+}
+
+//showDashboardTask edit the dashboard into the card of one of its tasks,
+//with a button for each status it can move to
+func (b Bot) showDashboardTask(task TaskDB, edit *tb.Message) {
+	attachments, _ := b.storage.GetAttachments(task.ID)
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	for _, status := range workflowOf(b.storage, task.ProjectID).Next(task.Status) {
+		row = append(row, dataButton(dashStatusBtn, "→ "+status, fmt.Sprintf("%d|%s", task.ID, status)))
+		if len(row) == 2 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	keys = append(keys, []tb.InlineButton{dataButton(dashTaskBtn, "« Back", "")})
+	b.bot.Edit(edit, b.taskDetailText(task, edit.Chat.ID, attachments), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//dashboardTask get a task of a dashboard button, if it still is assigned to the presser
+func (b Bot) dashboardTask(c *tb.Callback, taskID int) (TaskDB, bool) {
+	task, err := b.storage.GetTask(taskID)
+	if err != nil || !includes(task.Assignees, Person{ID: c.Sender.ID}) {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task is not assigned to you anymore"})
+		return task, false
+	}
+	return task, true
+}
+
+//handleDashboardTask open a task of the dashboard, or go back to it
+func (b Bot) handleDashboardTask(c *tb.Callback) {
+	if c.Data == "" {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		b.showDashboard(callbackMessage(c), c.Message)
+		return
+	}
+	taskID, _ := strconv.Atoi(c.Data)
+	task, ok := b.dashboardTask(c, taskID)
+	if !ok {
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.showDashboardTask(task, c.Message)
+}
+
+//handleDashboardStatus move a task from the dashboard, with the permissions
+//the presser has in the chat of the task, and tell that chat
+func (b Bot) handleDashboardStatus(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, "|", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	status := parts[1]
+	task, ok := b.dashboardTask(c, taskID)
+	if !ok {
+		return
+	}
+	origin := &tb.Chat{ID: b.originChat(task)}
+	if ok, refusal := b.allowed(origin, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	workflow := workflowOf(b.storage, task.ProjectID)
+	if !contains(workflow.Next(task.Status), status) {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("This task cannot move to %s anymore", status)})
+		return
+	}
+	if blocked := b.blockedMove(task, status); blocked != "" {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: blocked, ShowAlert: true})
+		return
+	}
+	if _, err := b.storage.GetChatTask(origin.ID, task.ID); err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task has no chat anymore, move it with /set_status"})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Moved to %s", status)})
+	// setStatus tells the chat of the task and runs what follows a move
+	b.setStatus(task.ID, status, &tb.Message{Sender: c.Sender, Chat: origin})
+	if moved, err := b.storage.GetTask(task.ID); err == nil {
+		task = moved
+	}
+	b.showDashboardTask(task, c.Message)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnknownDate = errors.New("cannot understand this date, try \"tomorrow 5pm\", \"next friday\", \"in 3 days\", \"2018-11-01\" or \"12/04\"")
+
+var (
+	inPattern      = regexp.MustCompile(`^in (\d+) ?(minutes?|mins?|m|hours?|h|days?|d|weeks?|w|months?)$`)
+	isoPattern     = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	numericPattern = regexp.MustCompile(`^(\d{1,2})[/.](\d{1,2})(?:[/.](\d{2}|\d{4}))?$`)
+	clockPattern   = regexp.MustCompile(`^(?:at )?(\d{1,2})(?:(?::|h)(\d{2})?)?(?: ?(am|pm))?$`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+//DateLocale parse and render deadlines the way a chat writes them
+type DateLocale struct {
+	Location *time.Location
+	// DayFirst reads 12/04 as the 12th of April instead of December 4th
+	DayFirst bool
+	// KeepPast leaves a passed day/month without a year in the current year
+	// instead of taking next year's, for deadlines written long ago
+	KeepPast bool
+	Now      func() time.Time
+}
+
+func (l DateLocale) now() time.Time {
+	if l.Now == nil {
+		return time.Now().In(l.Location)
+	}
+	return l.Now().In(l.Location)
+}
+
+//endOfDay is the time given to deadlines written without a time of day
+func endOfDay(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 23, 59, 0, 0, day.Location())
+}
+
+func isEndOfDay(t time.Time) bool {
+	return t.Hour() == 23 && t.Minute() == 59
+}
+
+//Parse read a deadline such as "tomorrow 5pm", "next friday", "in 3 days",
+//"2018-11-01" or "12/04". A date without a time of day is due at its end.
+//A weekday, with or without "next", is its first occurrence after today, and
+//a day/month without a year that has already passed is taken as next year's.
+func (l DateLocale) Parse(text string) (time.Time, error) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	now := l.now()
+	if text == "" {
+		return time.Time{}, errUnknownDate
+	}
+	if match := inPattern.FindStringSubmatch(text); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		switch unit := match[2]; {
+		case strings.HasPrefix(unit, "mo"):
+			return now.AddDate(0, n, 0), nil
+		case strings.HasPrefix(unit, "m"):
+			return now.Add(time.Duration(n) * time.Minute), nil
+		case strings.HasPrefix(unit, "h"):
+			return now.Add(time.Duration(n) * time.Hour), nil
+		case strings.HasPrefix(unit, "d"):
+			return endOfDay(now.AddDate(0, 0, n)), nil
+		default:
+			return endOfDay(now.AddDate(0, 0, 7*n)), nil
+		}
+	}
+
+	words := strings.Fields(text)
+	for split := len(words); split > 0; split-- {
+		day, ok := l.parseDay(strings.Join(words[:split], " "), now)
+		if !ok {
+			continue
+		}
+		if split == len(words) {
+			return endOfDay(day), nil
+		}
+		hour, minute, ok := parseClock(strings.Join(words[split:], " "))
+		if !ok {
+			return time.Time{}, errUnknownDate
+		}
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, l.Location), nil
+	}
+
+	// a time alone is today, or tomorrow when it has already passed
+	if hour, minute, ok := parseClock(text); ok {
+		deadline := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, l.Location)
+		if deadline.Before(now) {
+			deadline = deadline.AddDate(0, 0, 1)
+		}
+		return deadline, nil
+	}
+	return time.Time{}, errUnknownDate
+}
+
+//parseDay read the day part of a deadline
+func (l DateLocale) parseDay(text string, now time.Time) (time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Location)
+	switch text {
+	case "today", "tonight":
+		return today, true
+	case "tomorrow", "tmr":
+		return today.AddDate(0, 0, 1), true
+	case "next week":
+		return today.AddDate(0, 0, 7), true
+	case "next month":
+		return today.AddDate(0, 1, 0), true
+	}
+	if weekday, ok := weekdays[strings.TrimPrefix(text, "next ")]; ok {
+		days := (int(weekday) - int(today.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return today.AddDate(0, 0, days), true
+	}
+	if match := isoPattern.FindStringSubmatch(text); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		return l.date(year, month, day)
+	}
+	if match := numericPattern.FindStringSubmatch(text); match != nil {
+		day, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		if !l.DayFirst {
+			day, month = month, day
+		}
+		if match[3] == "" {
+			date, ok := l.date(today.Year(), month, day)
+			if ok && date.Before(today) && !l.KeepPast {
+				date, ok = l.date(today.Year()+1, month, day)
+			}
+			return date, ok
+		}
+		year, _ := strconv.Atoi(match[3])
+		if year < 100 {
+			year += 2000
+		}
+		return l.date(year, month, day)
+	}
+	return time.Time{}, false
+}
+
+//date build a date, refusing overflowing values like 31/02
+func (l DateLocale) date(year, month, day int) (time.Time, bool) {
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, l.Location)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+//parseClock read a time of day like "5pm", "5:30 pm", "17:00" or "noon"
+func parseClock(text string) (int, int, bool) {
+	switch strings.TrimPrefix(text, "at ") {
+	case "noon":
+		return 12, 0, true
+	case "midnight":
+		return 23, 59, true
+	}
+	match := clockPattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, 0, false
+	}
+	hour, _ := strconv.Atoi(match[1])
+	minute, _ := strconv.Atoi(match[2])
+	if match[3] != "" && (hour == 0 || hour > 12) {
+		return 0, 0, false
+	}
+	switch match[3] {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	default:
+		// a bare number is only a time when written like 17h or 17:00
+		if match[2] == "" && !strings.HasSuffix(text, "h") && !strings.HasSuffix(text, ":") {
+			return 0, 0, false
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+//Format render a deadline in the chat time zone and date order
+func (l DateLocale) Format(deadline time.Time) string {
+	if deadline.IsZero() {
+		return ""
+	}
+	deadline = deadline.In(l.Location)
+	layout := "Mon 01/02"
+	if l.DayFirst {
+		layout = "Mon 02/01"
+	}
+	if deadline.Year() != l.now().Year() {
+		layout += "/2006"
+	}
+	if !isEndOfDay(deadline) {
+		layout += " 15:04"
+	}
+	return deadline.Format(layout)
+}
+
+//formatDeadline render the deadline of a task, including text the
+//migration from free text deadlines could not understand
+func (l DateLocale) formatDeadline(task TaskDB) string {
+	if task.Deadline.IsZero() && task.DeadlineText != "" {
+		return fmt.Sprintf("%s (unrecognised)", task.DeadlineText)
+	}
+	return l.Format(task.Deadline)
+}
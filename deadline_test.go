@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testNow is a Friday, close to the end of the year to test year rollover
+var testNow = time.Date(2018, 12, 28, 10, 0, 0, 0, time.UTC)
+
+func testLocale(dayFirst bool) DateLocale {
+	return DateLocale{
+		Location: time.UTC,
+		DayFirst: dayFirst,
+		Now:      func() time.Time { return testNow },
+	}
+}
+
+func TestDateLocaleParse(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		text     string
+		dayFirst bool
+		want     time.Time
+	}{
+		{"in 30 minutes", true, testNow.Add(30 * time.Minute)},
+		{"in 2h", true, testNow.Add(2 * time.Hour)},
+		{"in 3 days", true, at(2018, 12, 31, 23, 59)},
+		{"in 1 week", true, at(2019, 1, 4, 23, 59)},
+		{"in 1 month", true, testNow.AddDate(0, 1, 0)},
+		{"today", true, at(2018, 12, 28, 23, 59)},
+		{"tomorrow 5pm", true, at(2018, 12, 29, 17, 0)},
+		{"Tomorrow  at 9:30 am", true, at(2018, 12, 29, 9, 30)},
+		{"tmr noon", true, at(2018, 12, 29, 12, 0)},
+		{"next week", true, at(2019, 1, 4, 23, 59)},
+		{"monday", true, at(2018, 12, 31, 23, 59)},
+		{"next friday", true, at(2019, 1, 4, 23, 59)},
+		{"fri 17h", true, at(2019, 1, 4, 17, 0)},
+		{"2019-02-01", true, at(2019, 2, 1, 23, 59)},
+		{"2019-02-01 17:00", true, at(2019, 2, 1, 17, 0)},
+		{"12/04", true, at(2019, 4, 12, 23, 59)},
+		{"12/04", false, at(2019, 12, 4, 23, 59)},
+		{"30.12", true, at(2018, 12, 30, 23, 59)},
+		{"02/01", true, at(2019, 1, 2, 23, 59)},
+		{"28/12", true, at(2018, 12, 28, 23, 59)},
+		{"12/04/20", true, at(2020, 4, 12, 23, 59)},
+		{"12/04/2017", true, at(2017, 4, 12, 23, 59)},
+		{"11am", true, at(2018, 12, 28, 11, 0)},
+		{"9:00", true, at(2018, 12, 29, 9, 0)},
+	}
+	for _, test := range tests {
+		got, err := testLocale(test.dayFirst).Parse(test.text)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %s", test.text, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("Parse(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestDateLocaleParseKeepPast(t *testing.T) {
+	locale := testLocale(true)
+	locale.KeepPast = true
+	tests := map[string]time.Time{
+		"12/04": time.Date(2018, 4, 12, 23, 59, 0, 0, time.UTC),
+		"30/12": time.Date(2018, 12, 30, 23, 59, 0, 0, time.UTC),
+	}
+	for text, want := range tests {
+		if got, err := locale.Parse(text); err != nil || !got.Equal(want) {
+			t.Errorf("Parse(%q) = %s, %v, want %s", text, got, err, want)
+		}
+	}
+}
+
+func TestDateLocaleParseErrors(t *testing.T) {
+	for _, text := range []string{"", "someday", "31/02", "2019-13-01", "tomorrow later", "17", "13pm", "25:00", "fix the bug"} {
+		if got, err := testLocale(true).Parse(text); err == nil {
+			t.Errorf("Parse(%q) = %s, want an error", text, got)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+var errSelfDependency = errors.New("a task cannot depend on itself")
+
+var errDependencyCycle = errors.New("it would make a cycle, the other task already depends on this one")
+
+// blockerMatcher matches tasks blocked by a task
+type blockerMatcher struct {
+	taskID int
+}
+
+func (b blockerMatcher) MatchField(v interface{}) (bool, error) {
+	blockers, ok := v.([]int)
+	if !ok {
+		return false, nil
+	}
+	for _, blocker := range blockers {
+		if blocker == b.taskID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//blockedBy match tasks that cannot start until a task is done
+func blockedBy(taskID int) q.Matcher {
+	return q.NewFieldMatcher("BlockedBy", blockerMatcher{taskID})
+}
+
+//blockersText write the ids of blocking tasks for the activity log, eg: "3, 5"
+func blockersText(blockers []int) string {
+	list := []string{}
+	for _, blocker := range blockers {
+		list = append(list, strconv.Itoa(blocker))
+	}
+	return strings.Join(list, ", ")
+}
+
+//openBlockers get the tasks blocking a task that are not done yet
+//Deleted blockers do not block anymore.
+func (b Bot) openBlockers(task TaskDB) []TaskDB {
+	open := []TaskDB{}
+	for _, blockerID := range task.BlockedBy {
+		blocker, err := b.storage.GetTask(blockerID)
+		if err == nil && !isClosed(b.storage, blocker) {
+			open = append(open, blocker)
+		}
+	}
+	return open
+}
+
+//dependsOn tell whether a task depends on another one, directly or through
+//the tasks it depends on
+func (b Bot) dependsOn(task TaskDB, otherID int) bool {
+	seen := map[int]bool{}
+	next := []int{task.ID}
+	for len(next) > 0 {
+		taskID := next[0]
+		next = next[1:]
+		if taskID == otherID {
+			return true
+		}
+		if seen[taskID] {
+			continue
+		}
+		seen[taskID] = true
+		current, err := b.storage.GetTask(taskID)
+		if err != nil {
+			continue
+		}
+		next = append(next, current.BlockedBy...)
+	}
+	return false
+}
+
+//taskList render tasks as their keys and titles, eg: "OPS-3 Fix login, OPS-4 Deploy"
+func (b Bot) taskList(tasks []TaskDB) string {
+	list := []string{}
+	for _, task := range tasks {
+		list = append(list, fmt.Sprintf("%s %s", b.taskKey(task), task.Title))
+	}
+	return strings.Join(list, ", ")
+}
+
+//dependencyText tell what a task waits for and what waits for it, in markdown
+func (b Bot) dependencyText(task TaskDB) string {
+	message := ""
+	if blockers := b.openBlockers(task); len(blockers) > 0 {
+		message += fmt.Sprintf("Blocked by: %s\n", escapeMarkdown(b.taskList(blockers)))
+	}
+	if dependents, _ := b.storage.FindTasks(blockedBy(task.ID)); len(dependents) > 0 {
+		message += fmt.Sprintf("Blocks: %s\n", escapeMarkdown(b.taskList(dependents)))
+	}
+	return message
+}
+
+//handleDepends make a task wait for other tasks to be done, or show what it
+//waits for, eg: /depends OPS-5 OPS-3
+func (b Bot) handleDepends(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot add dependency: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		b.replyAbout(task, m, fmt.Sprintf("*%s* *%s*\n%s", b.taskKey(task), task.Title,
+			orNone(b.dependencyText(task))), tb.ModeMarkdown)
+		return
+	}
+	for _, ref := range args {
+		blocker, err := b.findTask(m.Chat.ID, ref)
+		if err == nil && blocker.ID == task.ID {
+			err = errSelfDependency
+		} else if err == nil && b.dependsOn(blocker, task.ID) {
+			err = errDependencyCycle
+		}
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot make %s depend on %s: %s", b.taskKey(task), ref, err.Error()))
+			return
+		}
+		if !containsID(task.BlockedBy, blocker.ID) {
+			task.BlockedBy = append(task.BlockedBy, blocker.ID)
+		}
+	}
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot add dependency: %s", err.Error()))
+		return
+	}
+	b.replyAbout(task, m, fmt.Sprintf("*%s* *%s*\n%s", b.taskKey(task), task.Title,
+		orNone(b.dependencyText(task))), tb.ModeMarkdown)
+}
+
+//handleUnblock remove dependencies of a task, the given ones or all of them,
+//eg: /unblock OPS-5 OPS-3
+func (b Bot) handleUnblock(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot remove dependency: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		task.BlockedBy = nil
+	}
+	for _, ref := range args {
+		blocker, err := b.findTask(m.Chat.ID, ref)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot remove dependency: %s", err.Error()))
+			return
+		}
+		kept := []int{}
+		for _, blockerID := range task.BlockedBy {
+			if blockerID != blocker.ID {
+				kept = append(kept, blockerID)
+			}
+		}
+		task.BlockedBy = kept
+	}
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot remove dependency: %s", err.Error()))
+		return
+	}
+	b.replyAbout(task, m, fmt.Sprintf("*%s* *%s*\n%s", b.taskKey(task), task.Title,
+		orNone(b.dependencyText(task))), tb.ModeMarkdown)
+}
+
+func containsID(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//blockedMove tell why a task cannot move to a status while tasks it depends
+//on are open, empty when it can
+//Blocked tasks may stay in or go back to the first state, and be closed.
+func (b Bot) blockedMove(task TaskDB, status string) string {
+	workflow := workflowOf(b.storage, task.ProjectID)
+	if status == workflow.Initial() || workflow.IsClosed(status) {
+		return ""
+	}
+	blockers := b.openBlockers(task)
+	if len(blockers) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s cannot move to %s before %s is done, or /unblock it", b.taskKey(task), status, b.taskList(blockers))
+}
+
+//unblockDependents tell the chat and the assignees of the tasks waiting for a
+//task that was just closed
+func (b Bot) unblockDependents(task TaskDB, m *tb.Message) {
+	if !isClosed(b.storage, task) {
+		return
+	}
+	dependents, err := b.storage.FindTasks(blockedBy(task.ID))
+	if err != nil {
+		return
+	}
+	chat := b.chatOf(task, m)
+	for _, dependent := range dependents {
+		message := fmt.Sprintf("🔓 *%s* is done, *%s* *%s* can start", task.Title, b.taskKey(dependent), dependent.Title)
+		if blockers := b.openBlockers(dependent); len(blockers) > 0 {
+			message = fmt.Sprintf("*%s* is done, *%s* *%s* still waits for %s", task.Title, b.taskKey(dependent),
+				dependent.Title, b.taskList(blockers))
+		}
+		b.sendAbout(dependent, chat, message, tb.ModeMarkdown)
+		for _, assignee := range b.knownPeople(dependent.Assignees) {
+			if assignee.ID != 0 && assignee.ID != m.Sender.ID {
+				// users have to start a private chat with the bot first
+				b.bot.Send(&tb.User{ID: assignee.ID}, message, tb.ModeMarkdown)
+			}
+		}
+	}
+}
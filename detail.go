@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// attachmentBtn sends back a file attached to a task, its data is the attachment id
+var attachmentBtn = tb.InlineButton{Unique: "attachment"}
+
+//relativeTime tell how far a time is from now, eg: "in 3 days" or "2 hours ago"
+func relativeTime(t, now time.Time) string {
+	if t.After(now) {
+		return "in " + humanDuration(t.Sub(now))
+	}
+	return humanDuration(now.Sub(t)) + " ago"
+}
+
+//taskDetailText render every field of a task, its status history and attachments
+func (b Bot) taskDetailText(task TaskDB, chatID int64, attachments []Attachment) string {
+	project, _ := b.storage.GetProject(task.ProjectID)
+	workflow := workflowOf(b.storage, task.ProjectID)
+	locale := b.locale(chatID)
+	deadline := locale.formatDeadline(task)
+	if !task.Deadline.IsZero() {
+		deadline += fmt.Sprintf(" (%s)", relativeTime(task.Deadline, locale.now()))
+	}
+	message := fmt.Sprintf("*%s* *%s*\n", b.taskKey(task), escapeMarkdown(task.Title))
+	message += fmt.Sprintf("Project: %s\n", orNone(escapeMarkdown(project.Title)))
+	message += fmt.Sprintf("Status: %s\n", orNone(escapeMarkdown(workflow.Normalize(task.Status))))
+	message += fmt.Sprintf("Assignees: %s\n", orNone(escapeMarkdown(names(task.Assignees))))
+	message += fmt.Sprintf("Watchers: %s\n", orNone(escapeMarkdown(names(task.Watchers))))
+	message += fmt.Sprintf("Creator: %s\n", orNone(escapeMarkdown(task.Creator)))
+	message += fmt.Sprintf("Deadline: %s\n", orNone(escapeMarkdown(deadline)))
+	message += fmt.Sprintf("Priority: %s\n", orNone(task.Priority))
+	message += fmt.Sprintf("Labels: %s\n", orNone(escapeMarkdown(labelsText(task.Labels))))
+	if task.Recurrence != "" {
+		message += fmt.Sprintf("Repeats: %s\n", escapeMarkdown(task.Recurrence))
+	}
+	message += fmt.Sprintf("Description: %s\n", orNone(escapeMarkdown(task.Description)))
+	if task.ParentID != 0 {
+		if parent, err := b.storage.GetTask(task.ParentID); err == nil {
+			message += fmt.Sprintf("Subtask of: %s %s\n", b.taskKey(parent), escapeMarkdown(parent.Title))
+		}
+	}
+	if progress := b.progressText(task); progress != "" {
+		message += fmt.Sprintf("Progress: %s\n", progress)
+	}
+	message += b.dependencyText(task)
+	if children := b.subtasks(task); len(children) > 0 {
+		message += "\nSubtasks:\n"
+		for _, child := range children {
+			message += fmt.Sprintf("%s %s %s - %s\n", checkMark(isClosed(b.storage, child)), b.taskKey(child),
+				escapeMarkdown(child.Title), escapeMarkdown(workflowOf(b.storage, child.ProjectID).Normalize(child.Status)))
+		}
+	}
+	if len(task.Checklist) > 0 {
+		message += "\nChecklist:\n" + checklistText(task.Checklist)
+	}
+	if len(task.History) > 0 {
+		message += "\nHistory:\n"
+		for _, change := range task.History {
+			message += fmt.Sprintf("%s - %s", locale.Format(change.At), escapeMarkdown(change.Status))
+			if change.By != "" {
+				message += fmt.Sprintf(" by %s", escapeMarkdown(change.By))
+			}
+			message += "\n"
+		}
+	}
+	comments, _ := b.storage.GetComments(task.ID)
+	if len(comments) > 0 {
+		message += "\nComments:\n" + commentLines(comments, locale, threadComments)
+	}
+	if len(attachments) > 0 {
+		message += fmt.Sprintf("\n%d attachments, reply a file to this task to add one\n", len(attachments))
+	}
+	return message
+}
+
+//taskDetailKeyboard is an edit button for each field of a task,
+//and a button sending each of its attachments
+func taskDetailKeyboard(task TaskDB, attachments []Attachment) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{
+		{
+			taskActionButton("✏️ Title", actionTitle, task.ID),
+			taskActionButton("📝 Description", actionDescription, task.ID),
+		},
+		{
+			taskActionButton("👤 Assignee", actionAssign, task.ID),
+			taskActionButton("📅 Deadline", actionDeadline, task.ID),
+		},
+		{
+			taskActionButton("⚡ Priority", actionPriority, task.ID),
+			taskActionButton("🏷 Labels", actionLabels, task.ID),
+		},
+		{
+			taskActionButton("➕ Subtask", actionSubtask, task.ID),
+			taskActionButton("☑️ Checklist", actionChecklist, task.ID),
+		},
+		{
+			taskActionButton("🔀 Status", actionStatus, task.ID),
+			taskActionButton("📁 Project", actionMove, task.ID),
+		},
+		{
+			taskActionButton("🗑 Delete", actionDelete, task.ID),
+		},
+	}
+	for _, attachment := range attachments {
+		keys = append(keys, []tb.InlineButton{
+			dataButton(attachmentBtn, "📎 "+shorten(attachment.FileName, maxButtonTitle), strconv.Itoa(attachment.ID)),
+		})
+	}
+	return keys
+}
+
+//showTaskDetail send the full card of a task
+func (b Bot) showTaskDetail(task TaskDB, m *tb.Message) {
+	attachments, _ := b.storage.GetAttachments(task.ID)
+	b.sendAbout(task, m.Chat, b.taskDetailText(task, m.Chat.ID, attachments), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: taskDetailKeyboard(task, attachments)},
+	})
+}
+
+func (b Bot) handleDetail(m *tb.Message) {
+	var task TaskDB
+	var err error
+	if ref := strings.TrimSpace(m.Payload); ref != "" {
+		task, err = b.findTask(m.Chat.ID, ref)
+	} else if m.IsReply() {
+		task, err = b.repliedTask(m)
+	} else {
+		b.bot.Reply(m, "You should reply to a task, or give its id or key, to show its detail")
+		return
+	}
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get task: %s", err.Error()))
+		return
+	}
+	b.showTaskDetail(task, m)
+}
+
+//editTaskText change the title or the description of the session task
+func (b Bot) editTaskText(state SessionState, taskID int, text string, m *tb.Message) {
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+		return
+	}
+	text = strings.TrimSpace(text)
+	switch state {
+	case StateEditTitle:
+		if text == "" {
+			b.bot.Reply(m, "Cannot edit task: the title cannot be empty")
+			return
+		}
+		task.Title = text
+	case StateEditDescription:
+		if text == "none" {
+			text = ""
+		}
+		task.Description = text
+	}
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+		return
+	}
+	b.showTaskDetail(task, m)
+}
+
+//handleAttachment attach a photo or file sent in reply to a task
+func (b Bot) handleAttachment(m *tb.Message) {
+	if !m.IsReply() {
+		return
+	}
+	task, err := b.repliedTask(m)
+	if err != nil || !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	attachment := Attachment{
+		TaskID: task.ID,
+		By:     displayName(m.Sender),
+		At:     time.Now(),
+	}
+	switch {
+	case m.Photo != nil:
+		attachment.FileID = m.Photo.FileID
+		attachment.FileName = "photo"
+		attachment.Photo = true
+	case m.Document != nil:
+		attachment.FileID = m.Document.FileID
+		attachment.FileName = m.Document.FileName
+	default:
+		return
+	}
+	if m.Caption != "" {
+		attachment.FileName = m.Caption
+	}
+	if err := b.storage.StoreAttachment(attachment); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot attach file: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("📎 Attached %s to *%s*", escapeMarkdown(attachment.FileName), escapeMarkdown(task.Title)), tb.ModeMarkdown)
+}
+
+//handleAttachmentButton send an attached file back to the chat
+func (b Bot) handleAttachmentButton(c *tb.Callback) {
+	attachmentID, _ := strconv.Atoi(c.Data)
+	attachment, err := b.storage.GetAttachment(attachmentID)
+	if err == nil {
+		_, err = b.storage.GetChatTask(c.Message.Chat.ID, attachment.TaskID)
+	}
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This file is no longer attached"})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	file := tb.File{FileID: attachment.FileID}
+	if attachment.Photo {
+		b.bot.Send(c.Message.Chat, &tb.Photo{File: file, Caption: attachment.FileName})
+		return
+	}
+	b.bot.Send(c.Message.Chat, &tb.Document{File: file, FileName: attachment.FileName})
+}
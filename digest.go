@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// A digest is set with a time of day, weekdays and optionally a time zone,
+// in any order, eg: /digest 9:00 mon-fri Europe/Berlin. Weekdays are names,
+// ranges like mon-fri, lists like mon,thu, daily or weekdays.
+
+var errBadDigest = errors.New("give a time and weekdays, eg: /digest 9:00 mon-fri, /digest 8am daily Asia/Ho_Chi_Minh, /digest now or /digest off")
+
+// digestInterval is how often digests are checked
+const digestInterval = time.Minute
+
+// digestLines is how many tasks each part of a digest shows
+const digestLines = 10
+
+// defaultDigestHour is the time of digests set without one
+const defaultDigestHour = 9
+
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+//parseWeekdays read weekdays like mon, mon-fri, mon,thu, daily or weekdays
+func parseWeekdays(text string) ([]time.Weekday, bool) {
+	switch text {
+	case "daily", "everyday":
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, true
+	case "weekdays":
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, true
+	}
+	days := []time.Weekday{}
+	for _, part := range strings.Split(text, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, false
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, false
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, true
+}
+
+//parseDigest read the time, weekdays and time zone of a digest,
+//the time zone is empty when none is given
+func parseDigest(text string) (Digest, string, error) {
+	digest := Digest{Hour: defaultDigestHour}
+	timeZone := ""
+	for _, word := range strings.Fields(text) {
+		lower := strings.ToLower(word)
+		if hour, minute, ok := parseClock(lower); ok {
+			digest.Hour, digest.Minute = hour, minute
+			continue
+		}
+		if days, ok := parseWeekdays(lower); ok {
+			digest.Weekdays = append(digest.Weekdays, days...)
+			continue
+		}
+		if _, err := time.LoadLocation(word); err == nil && word != "Local" {
+			timeZone = word
+			continue
+		}
+		return digest, "", errBadDigest
+	}
+	if len(digest.Weekdays) == 0 {
+		digest.Weekdays, _ = parseWeekdays("daily")
+	}
+	seen := map[time.Weekday]bool{}
+	days := []time.Weekday{}
+	for _, day := range digest.Weekdays {
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	digest.Weekdays = days
+	return digest, timeZone, nil
+}
+
+//String tell when a digest is sent, eg: "at 09:00 on Mon, Tue"
+func (d Digest) String() string {
+	if len(d.Weekdays) == 0 {
+		return "off"
+	}
+	days := []string{}
+	for _, day := range d.Weekdays {
+		days = append(days, weekdayNames[day])
+	}
+	return fmt.Sprintf("at %02d:%02d on %s", d.Hour, d.Minute, strings.Join(days, ", "))
+}
+
+//due tell whether the digest of today should be sent at a time
+//A digest missed while the bot was down is sent late the same day only.
+func (d Digest) due(now time.Time) bool {
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), d.Hour, d.Minute, 0, 0, now.Location())
+	for _, day := range d.Weekdays {
+		if day == now.Weekday() {
+			return !now.Before(scheduled) && d.SentAt.Before(scheduled)
+		}
+	}
+	return false
+}
+
+//digestSection render a part of a digest, one line per task
+func (b Bot) digestSection(title string, tasks []TaskDB, locale DateLocale) string {
+	if len(tasks) == 0 {
+		return ""
+	}
+	message := fmt.Sprintf("\n*%s (%d)*\n", title, len(tasks))
+	for i, task := range tasks {
+		if i == digestLines {
+			message += fmt.Sprintf("… and %d more\n", len(tasks)-digestLines)
+			break
+		}
+		message += fmt.Sprintf("%s *%s* - %s - %s\n", b.taskKey(task), escapeMarkdown(task.Title),
+			orNone(escapeMarkdown(names(task.Assignees))), escapeMarkdown(locale.formatDeadline(task)))
+	}
+	return message
+}
+
+//digestText render the digest of a chat: tasks overdue, due today, done since
+//the last digest and, in groups, how many open tasks each assignee has
+func (b Bot) digestText(settings ChatSettings) (string, error) {
+	var tasks []TaskDB
+	var err error
+	if settings.Digest.Private {
+		tasks, err = b.storage.FindTasks(hasPerson("Assignees", Person{ID: int(settings.ChatID)}))
+	} else {
+		var scope q.Matcher
+		scope, err = b.chatScope(settings.ChatID)
+		if err == nil {
+			tasks, err = b.storage.FindTasks(scope)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	locale := b.localeOf(settings)
+	now := locale.now()
+	since := settings.Digest.SentAt
+	if since.IsZero() {
+		since = now.AddDate(0, 0, -1)
+	}
+	var overdue, today, done []TaskDB
+	load := newWorkload()
+	for _, task := range tasks {
+		if isClosed(b.storage, task) {
+			if n := len(task.History); n > 0 && task.History[n-1].At.After(since) {
+				done = append(done, task)
+			}
+			continue
+		}
+		load.add(task, now)
+		switch {
+		case task.Deadline.IsZero():
+		case task.Deadline.Before(now):
+			overdue = append(overdue, task)
+		case !task.Deadline.After(endOfDay(now)):
+			today = append(today, task)
+		}
+	}
+	byDeadline := func(tasks []TaskDB) {
+		sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Deadline.Before(tasks[j].Deadline) })
+	}
+	byDeadline(overdue)
+	byDeadline(today)
+	message := fmt.Sprintf("☀️ Digest of %s\n", now.Format("Monday 02 Jan"))
+	if settings.Digest.Private {
+		message = fmt.Sprintf("☀️ Your digest of %s\n", now.Format("Monday 02 Jan"))
+	}
+	message += b.digestSection("⚠️ Overdue", overdue, locale)
+	message += b.digestSection("📅 Due today", today, locale)
+	message += b.digestSection("✅ Done since last digest", done, locale)
+	if len(overdue)+len(today)+len(done) == 0 {
+		message += "\nNothing overdue, nothing due today 🎉\n"
+	}
+	if !settings.Digest.Private {
+		message += load.text("Open tasks")
+	}
+	return message, nil
+}
+
+//workload count the open tasks of each assignee, and how many of them are
+//overdue, by escaped assignee name
+type workload struct {
+	open map[string]int
+	late map[string]int
+}
+
+func newWorkload() workload {
+	return workload{open: map[string]int{}, late: map[string]int{}}
+}
+
+//add count an open task for each of its assignees, or for nobody
+func (w workload) add(task TaskDB, now time.Time) {
+	assignees := []string{orNone("")}
+	if len(task.Assignees) > 0 {
+		assignees = []string{}
+		for _, assignee := range task.Assignees {
+			assignees = append(assignees, escapeMarkdown(assignee.Name))
+		}
+	}
+	late := !task.Deadline.IsZero() && task.Deadline.Before(now)
+	for _, assignee := range assignees {
+		w.open[assignee]++
+		if late {
+			w.late[assignee]++
+		}
+	}
+}
+
+//text render the workload under a title, one line per assignee by name,
+//nothing when nobody has open tasks
+func (w workload) text(title string) string {
+	if len(w.open) == 0 {
+		return ""
+	}
+	assignees := []string{}
+	for assignee := range w.open {
+		assignees = append(assignees, assignee)
+	}
+	sort.Strings(assignees)
+	message := fmt.Sprintf("\n*%s*\n", title)
+	for _, assignee := range assignees {
+		message += fmt.Sprintf("%s: %d", assignee, w.open[assignee])
+		if w.late[assignee] > 0 {
+			message += fmt.Sprintf(" (%d overdue)", w.late[assignee])
+		}
+		message += "\n"
+	}
+	return message
+}
+
+//sendDigests send the digests that are due
+func (b Bot) sendDigests() {
+	chats, err := b.storage.GetDigestChats()
+	if err != nil {
+		return
+	}
+	for _, settings := range chats {
+		now := b.localeOf(settings).now()
+		if !settings.Digest.due(now) {
+			continue
+		}
+		message, err := b.digestText(settings)
+		if err != nil {
+			continue
+		}
+		chat := &tb.Chat{ID: settings.ChatID}
+		_, err = b.bot.Send(chat, message, tb.ModeMarkdown)
+		if err != nil && strings.Contains(err.Error(), "can't parse entities") {
+			// better a digest without formatting than none
+			_, err = b.bot.Send(chat, message)
+		}
+		if err != nil {
+			log.Printf("Cannot send digest to chat %d: %s", settings.ChatID, err.Error())
+			if !unreachable(err) {
+				// tried again at the next interval
+				continue
+			}
+		}
+		// a chat that cannot be reached is not retried until the next digest
+		// the settings may have changed while the digest was sent
+		b.storage.MarkDigestSent(settings.ChatID, now)
+	}
+}
+
+//unreachable tell whether telegram refused a message because the bot cannot
+//write to the chat anymore, eg: it was blocked or removed from the chat
+func unreachable(err error) bool {
+	text := err.Error()
+	return strings.Contains(text, "Forbidden") || strings.Contains(text, "chat not found")
+}
+
+//runDigests send the digests that are due, every interval
+func (b Bot) runDigests(interval time.Duration) {
+	for {
+		b.sendDigests()
+		time.Sleep(interval)
+	}
+}
+
+//handleDigest show, set or stop the digest of the chat, or send it now
+//In a private chat the digest lists the tasks of the user in every chat.
+func (b Bot) handleDigest(m *tb.Message) {
+	settings, err := b.storage.GetChatSettings(m.Chat.ID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get chat settings: %s", err.Error()))
+		return
+	}
+	payload := strings.TrimSpace(m.Payload)
+	switch strings.ToLower(payload) {
+	case "":
+		b.bot.Reply(m, fmt.Sprintf("The digest of this chat is %s, in the %s time zone. Change it with eg: /digest 9:00 mon-fri, or /digest off",
+			settings.Digest, b.localeOf(settings).Location))
+		return
+	case "now":
+		settings.Digest.Private = m.Chat.Type == tb.ChatPrivate
+		message, err := b.digestText(settings)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot make digest: %s", err.Error()))
+			return
+		}
+		b.bot.Send(m.Chat, message, tb.ModeMarkdown)
+		return
+	}
+	if !b.permitted(m, 0, permManageChat) {
+		return
+	}
+	if strings.ToLower(payload) == "off" {
+		settings.Digest = Digest{}
+	} else {
+		digest, timeZone, err := parseDigest(payload)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot set digest: %s", err.Error()))
+			return
+		}
+		if timeZone != "" {
+			settings.TimeZone = timeZone
+		}
+		digest.Private = m.Chat.Type == tb.ChatPrivate
+		// a time already passed today waits for the next day
+		digest.SentAt = time.Now()
+		settings.Digest = digest
+	}
+	if err := b.storage.StoreChatSettings(settings); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set digest: %s", err.Error()))
+		return
+	}
+	if len(settings.Digest.Weekdays) == 0 {
+		b.bot.Reply(m, "The digest of this chat is off")
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("The digest of this chat is now sent %s, in the %s time zone",
+		settings.Digest, b.localeOf(settings).Location))
+}
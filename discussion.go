@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// threadComments is how many of the latest comments a thread message shows
+const threadComments = 10
+
+//commentLines render the latest comments of a discussion
+func commentLines(comments []Comment, locale DateLocale, limit int) string {
+	message := ""
+	if len(comments) > limit {
+		message += fmt.Sprintf("… %d earlier comments\n", len(comments)-limit)
+		comments = comments[len(comments)-limit:]
+	}
+	for _, comment := range comments {
+		message += fmt.Sprintf("_%s_ %s: %s\n", locale.Format(comment.At), escapeMarkdown(comment.Author), escapeMarkdown(comment.Text))
+	}
+	return message
+}
+
+// markdownEscaper keeps user text from breaking the markdown of bot messages
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+//threadText render the discussion thread message of a task
+//Replying to it adds a comment.
+func (b Bot) threadText(task TaskDB, chatID int64) string {
+	comments, _ := b.storage.GetComments(task.ID)
+	message := fmt.Sprintf("*%s* 💬 *%s*\n", b.taskKey(task), task.Title)
+	if len(comments) == 0 {
+		return message + "No comment yet, reply to this message to start the discussion"
+	}
+	return message + commentLines(comments, b.locale(chatID), threadComments)
+}
+
+//updateThread edit the discussion thread message of a task in a chat,
+//or send a new one when there is none or it cannot be edited anymore
+func (b Bot) updateThread(task TaskDB, chat *tb.Chat) {
+	text := b.threadText(task, chat.ID)
+	thread, err := b.storage.GetDiscussionThread(task.ID, chat.ID)
+	if err != nil {
+		return
+	}
+	if thread.MessageID != 0 {
+		stored := tb.StoredMessage{MessageID: strconv.Itoa(thread.MessageID), ChatID: chat.ID}
+		if _, err := b.bot.Edit(stored, text, tb.ModeMarkdown); err == nil {
+			return
+		}
+	}
+	sent, err := b.sendAbout(task, chat, text, tb.ModeMarkdown)
+	if err != nil {
+		return
+	}
+	thread.TaskID = task.ID
+	thread.ChatID = chat.ID
+	thread.MessageID = sent.ID
+	b.storage.StoreDiscussionThread(thread)
+}
+
+//addComment add a message to the discussion of a task and update its thread
+func (b Bot) addComment(task TaskDB, text string, m *tb.Message) error {
+	err := b.storage.StoreComment(Comment{
+		TaskID:   task.ID,
+		AuthorID: m.Sender.ID,
+		Author:   displayName(m.Sender),
+		Text:     text,
+		At:       time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	b.updateThread(task, m.Chat)
+	return nil
+}
+
+//handleDiscuss comment on the replied task, or show its discussion thread
+func (b Bot) handleDiscuss(m *tb.Message) {
+	if !m.IsReply() {
+		b.bot.Reply(m, "You should reply to a task to discuss it, eg: /discuss should we split it?")
+		return
+	}
+	task, err := b.repliedTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot discuss task: %s", err.Error()))
+		return
+	}
+	text := strings.TrimSpace(m.Payload)
+	if text == "" {
+		// send the thread again at the bottom of the chat
+		thread, err := b.storage.GetDiscussionThread(task.ID, m.Chat.ID)
+		if err == nil && thread.MessageID != 0 {
+			thread.MessageID = 0
+			b.storage.StoreDiscussionThread(thread)
+		}
+		b.updateThread(task, m.Chat)
+		return
+	}
+	if err := b.addComment(task, text, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot discuss task: %s", err.Error()))
+	}
+}
+
+//commentOnReply add a plain reply to a task message of the bot to the
+//discussion of the task, and report whether the message was one
+func (b Bot) commentOnReply(m *tb.Message) bool {
+	if !m.IsReply() || m.ReplyTo.Sender == nil || m.ReplyTo.Sender.ID != b.bot.Me.ID {
+		return false
+	}
+	task, err := b.repliedTask(m)
+	if err != nil || !b.permitted(m, task.ProjectID, permEditTasks) {
+		return false
+	}
+	if err := b.addComment(task, strings.TrimSpace(m.Text), m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot discuss task: %s", err.Error()))
+	}
+	return true
+}
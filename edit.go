@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// taskMoveBtn moves a task to the project picked, its data is "<task id>:<project id>"
+var taskMoveBtn = tb.InlineButton{Unique: "task_move"}
+
+// editFields are the fields /edit changes and the sessions asking for them
+var editFields = map[string]SessionState{
+	"title":       StateEditTitle,
+	"description": StateEditDescription,
+	"desc":        StateEditDescription,
+}
+
+//afterWords return a text without its first words, keeping the lines of the rest
+func afterWords(text string, words int) string {
+	text = strings.TrimSpace(text)
+	for i := 0; i < words; i++ {
+		end := strings.IndexFunc(text, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' })
+		if end < 0 {
+			return ""
+		}
+		text = strings.TrimSpace(text[end:])
+	}
+	return text
+}
+
+//handleEdit change the title or the description of a task, or ask for it
+//when the command has no text, eg: /edit OPS-3 title Fix the login page
+func (b Bot) handleEdit(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		b.showTaskDetail(task, m)
+		return
+	}
+	state, ok := editFields[strings.ToLower(args[0])]
+	if !ok {
+		b.bot.Reply(m, "You can edit the title or the description of a task, eg: /edit OPS-3 title Fix the login page")
+		return
+	}
+	used := 1
+	if !m.IsReply() {
+		used = 2
+	}
+	text := afterWords(m.Payload, used)
+	if text != "" {
+		b.editTaskText(state, task.ID, text, m)
+		return
+	}
+	if err := b.sessions.Start(m, state, task.ID); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot edit task: %s", err.Error()))
+		return
+	}
+	if state == StateEditTitle {
+		b.bot.Reply(m, fmt.Sprintf("Send the new title of *%s*", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Send the new description of *%s*, or none", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+}
+
+//handleMove move a task to another project of the chat given by its id or
+//key, or pick it with buttons, eg: /move OPS-3 WEB
+func (b Bot) handleMove(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot move task: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		b.askProject(task, m)
+		return
+	}
+	project, err := b.findProject(m.Chat.ID, args[0])
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot move task: %s", err.Error()))
+		return
+	}
+	b.moveTask(task, project, m)
+}
+
+//askProject send a button for each other active project of the chat to move a task to
+func (b Bot) askProject(task TaskDB, m *tb.Message) {
+	projects, err := b.activeProjects(m.Chat.ID)
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot move task: %s", err.Error()))
+		return
+	}
+	keys := [][]tb.InlineButton{}
+	for _, project := range projects {
+		if project.ID == task.ProjectID {
+			continue
+		}
+		keys = append(keys, []tb.InlineButton{
+			dataButton(taskMoveBtn, shorten(project.Title, maxButtonTitle), fmt.Sprintf("%d:%d", task.ID, project.ID)),
+		})
+	}
+	if len(keys) == 0 {
+		b.bot.Send(m.Chat, "There is no other project in this chat to move the task to")
+		return
+	}
+	b.bot.Send(m.Chat, fmt.Sprintf("Move *%s* to which project?", escapeMarkdown(task.Title)), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//moveTask move a task to a project, it gets a new key there and the first
+//state of the workflow of the project if its status is not one of them
+func (b Bot) moveTask(task TaskDB, project ProjectDB, m *tb.Message) {
+	if project.ID == task.ProjectID {
+		b.bot.Send(m.Chat, fmt.Sprintf("*%s* already is in *%s*", escapeMarkdown(task.Title), escapeMarkdown(project.Title)), tb.ModeMarkdown)
+		return
+	}
+	if project.archived() {
+		b.bot.Send(m.Chat, fmt.Sprintf("*%s* is archived, tasks cannot be moved to it", escapeMarkdown(project.Title)), tb.ModeMarkdown)
+		return
+	}
+	if !b.permitted(m, project.ID, permEditTasks) {
+		return
+	}
+	workflow := workflowOf(b.storage, project.ID)
+	if !workflow.Has(workflow.Normalize(task.Status)) {
+		task.moveTo(workflow.Initial(), displayName(m.Sender), time.Now())
+	}
+	oldKey := b.taskKey(task)
+	if err := b.storage.MoveTask(&task, project.ID, actorOf(m)); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot move task: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Moved %s *%s* to *%s*, it is now *%s*",
+		oldKey, escapeMarkdown(task.Title), escapeMarkdown(project.Title), b.taskKey(task)), tb.ModeMarkdown)
+}
+
+//handleMoveButton move a task to the project picked
+func (b Bot) handleMoveButton(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	projectID, _ := strconv.Atoi(parts[1])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	project, err := b.storage.GetChatProject(c.Message.Chat.ID, projectID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This project does not belong to this chat anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.moveTask(task, project, callbackMessage(c))
+	b.bot.Delete(c.Message)
+}
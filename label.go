@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// taskUnlabelBtn removes a label of a task, its data is "<task id>:<label index>"
+// as labels can be too long for callback data
+var taskUnlabelBtn = tb.InlineButton{Unique: "task_unlabel"}
+
+// labelPattern is a label of the create syntax, short enough to fit in
+// the callback data of task lists
+var labelPattern = regexp.MustCompile(`^#[\p{L}\d_-]{1,20}$`)
+
+var errBadLabel = errors.New("labels are up to 20 letters, digits, - or _, eg: #backend")
+
+//normalizeLabel write a label without "#" and in lower case
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.TrimPrefix(label, "#"))
+}
+
+//labelsText render labels, eg: "#backend #api"
+func labelsText(labels []string) string {
+	list := []string{}
+	for _, label := range labels {
+		list = append(list, "#"+label)
+	}
+	return strings.Join(list, " ")
+}
+
+//changeLabels add and remove labels written as words, eg: "#api -frontend"
+//"none" removes every label.
+func changeLabels(labels []string, text string) ([]string, error) {
+	result := append([]string{}, labels...)
+	for _, word := range strings.Fields(text) {
+		if strings.ToLower(word) == "none" {
+			result = []string{}
+			continue
+		}
+		remove := strings.HasPrefix(word, "-")
+		label := "#" + strings.TrimPrefix(strings.TrimPrefix(word, "-"), "#")
+		if !labelPattern.MatchString(label) {
+			return labels, errBadLabel
+		}
+		label = normalizeLabel(label)
+		if remove {
+			result = removeLabel(result, label)
+		} else if !contains(result, label) {
+			result = append(result, label)
+		}
+	}
+	return result, nil
+}
+
+func removeLabel(labels []string, label string) []string {
+	kept := []string{}
+	for _, other := range labels {
+		if other != label {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// labelMatcher matches tasks having a label
+type labelMatcher struct {
+	label string
+}
+
+func (l labelMatcher) MatchField(v interface{}) (bool, error) {
+	labels, ok := v.([]string)
+	if !ok {
+		return false, nil
+	}
+	return contains(labels, l.label), nil
+}
+
+//hasLabel match tasks having a label
+func hasLabel(label string) q.Matcher {
+	return q.NewFieldMatcher("Labels", labelMatcher{label})
+}
+
+//handleLabel add or remove labels of a task, or show them with buttons
+//removing them, eg: /label OPS-3 #backend -frontend
+func (b Bot) handleLabel(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot label task: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		b.askLabels(task, m)
+		return
+	}
+	b.labelTask(task.ID, strings.Join(args, " "), m)
+}
+
+//askLabels show the labels of a task with a button removing each,
+//and wait for labels to add
+func (b Bot) askLabels(task TaskDB, m *tb.Message) {
+	if err := b.sessions.Start(m, StateSetLabels, task.ID); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot label task: %s", err.Error()))
+		return
+	}
+	keys := [][]tb.InlineButton{}
+	for i, label := range task.Labels {
+		keys = append(keys, []tb.InlineButton{
+			dataButton(taskUnlabelBtn, "✖ #"+label, fmt.Sprintf("%d:%d", task.ID, i)),
+		})
+	}
+	b.bot.Send(m.Chat, fmt.Sprintf("*%s* labels: %s\nSend labels to add, -label to remove one, or none",
+		escapeMarkdown(task.Title), orNone(escapeMarkdown(labelsText(task.Labels)))), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//labelTask change the labels of a task written as words and tell the chat
+func (b Bot) labelTask(taskID int, text string, m *tb.Message) {
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot label task: %s", err.Error()))
+		return
+	}
+	task.Labels, err = changeLabels(task.Labels, text)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot label task: %s", err.Error()))
+		return
+	}
+	b.saveLabels(task, m)
+}
+
+func (b Bot) saveLabels(task TaskDB, m *tb.Message) {
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot label task: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* labels: %s", escapeMarkdown(task.Title),
+		orNone(escapeMarkdown(labelsText(task.Labels)))), tb.ModeMarkdown)
+}
+
+//handleUnlabelButton remove the label picked
+func (b Bot) handleUnlabelButton(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	index, _ := strconv.Atoi(parts[1])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	if index < 0 || index >= len(task.Labels) {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This label was already removed"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	m := callbackMessage(c)
+	b.sessions.Finish(m)
+	task.Labels = removeLabel(task.Labels, task.Labels[index])
+	b.saveLabels(task, m)
+	b.bot.Delete(c.Message)
+}
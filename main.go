@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,16 +14,62 @@ import (
 // BotConfig object
 type BotConfig struct {
 	Key string `json:"bot_key"`
+	// SessionTimeout is how long an unfinished dialog is kept, eg: "10m"
+	SessionTimeout string `json:"session_timeout"`
+	// TimeZone and DateOrder are used by chats that did not set their own
+	TimeZone  string `json:"timezone"`
+	DateOrder string `json:"date_order"`
+	// ReminderOffsets are how long before a deadline to remind, eg: ["24h", "1h"]
+	ReminderOffsets []string `json:"reminder_offsets"`
+	// ReminderInterval is how often deadlines are checked, eg: "1m"
+	ReminderInterval string `json:"reminder_interval"`
+	// TrashRetention is how long deleted tasks can be restored, eg: "720h"
+	TrashRetention string `json:"trash_retention"`
 }
 
 //Bot object
 type Bot struct {
-	bot     *tb.Bot
-	storage *TaskStorage
+	bot       *tb.Bot
+	storage   *TaskStorage
+	sessions  *SessionManager
+	scheduler *Scheduler
+	defaults  ChatSettings
+	// trashRetention is how long deleted tasks can be restored
+	trashRetention time.Duration
 }
 
-var currentCommand map[string]string
-var currentTask int
+//dataButton copy a button registered in main with its text and callback data
+func dataButton(template tb.InlineButton, text, data string) tb.InlineButton {
+	template.Text = text
+	template.Data = data
+	return template
+}
+
+//mentionedUsernames return the usernames mentioned in a message, without "@"
+func mentionedUsernames(m *tb.Message) []string {
+	usernames := []string{}
+	for _, entity := range m.Entities {
+		if entity.Type == tb.EntityTMention && entity.User != nil {
+			usernames = append(usernames, entity.User.Username)
+		}
+	}
+	for _, word := range strings.Fields(m.Text) {
+		if mentionPattern.MatchString(word) {
+			usernames = append(usernames, strings.TrimPrefix(word, "@"))
+		}
+	}
+	return usernames
+}
+
+//callbackMessage build a message carrying the sender and chat of a callback
+//so callbacks can share the session helpers used by text messages.
+func callbackMessage(c *tb.Callback) *tb.Message {
+	return &tb.Message{
+		ID:     c.Message.ID,
+		Sender: c.Sender,
+		Chat:   c.Message.Chat,
+	}
+}
 
 func readConfigFromFile(path string) (BotConfig, error) {
 	data, err := ioutil.ReadFile(path)
@@ -43,71 +88,211 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	storage, err := NewStorage()
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+	poller := tb.NewMiddlewarePoller(&tb.LongPoller{Timeout: 5 * time.Second}, func(upd *tb.Update) bool {
+		recordUser(storage, upd)
+		return true
+	})
 	tbot, err := tb.NewBot(tb.Settings{
 		Token:  botConfig.Key,
-		Poller: &tb.LongPoller{Timeout: 5 * time.Second},
+		Poller: poller,
 	})
 	if err != nil {
 		log.Fatalf("Cannot initiate new bot: %s", err.Error())
 	}
-	storage, err := NewStorage()
+	sessionTimeout := defaultSessionTimeout
+	if botConfig.SessionTimeout != "" {
+		sessionTimeout, err = time.ParseDuration(botConfig.SessionTimeout)
+		if err != nil {
+			log.Fatalf("Invalid session timeout: %s", err.Error())
+		}
+	}
+	trashRetention := defaultTrashRetention
+	if botConfig.TrashRetention != "" {
+		trashRetention, err = time.ParseDuration(botConfig.TrashRetention)
+		if err != nil {
+			log.Fatalf("Invalid trash retention: %s", err.Error())
+		}
+	}
+	sessions := NewSessionManager(storage, sessionTimeout)
+	sessions.PurgeExpired()
 	mybot := Bot{
-		bot:     tbot,
-		storage: storage,
+		bot:      tbot,
+		storage:  storage,
+		sessions: sessions,
+		defaults: ChatSettings{
+			TimeZone:  botConfig.TimeZone,
+			DateOrder: botConfig.DateOrder,
+		},
+		trashRetention: trashRetention,
 	}
+	// tasks are decoded as TaskDB only once their deadline is a time, and
+	// saving them drops their legacy assignee, so the order matters
+	migrated, unparsed, err := storage.MigrateDeadlines(mybot.localeOf(ChatSettings{}))
+	if err != nil {
+		log.Panic(err)
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d task deadlines, %d could not be parsed", migrated, unparsed)
+	}
+	migrated, err = storage.MigrateAssignees()
+	if err != nil {
+		log.Panic(err)
+	}
+	if migrated > 0 {
+		log.Printf("Linked %d tasks to the id of their assignee", migrated)
+	}
+	migrated, err = storage.MigrateTaskKeys()
 	if err != nil {
 		log.Panic(err)
-		return
 	}
+	if migrated > 0 {
+		log.Printf("Numbered %d tasks in their project", migrated)
+	}
+	migrated, err = storage.MigrateProjectChats()
+	if err != nil {
+		log.Panic(err)
+	}
+	if migrated > 0 {
+		log.Printf("Gave an owner chat to %d projects", migrated)
+	}
+	migrated, err = storage.MigrateProjectCreators()
+	if err != nil {
+		log.Panic(err)
+	}
+	if migrated > 0 {
+		log.Printf("Linked %d projects to the id of their creator", migrated)
+	}
+	offsets := []time.Duration{}
+	for _, offset := range botConfig.ReminderOffsets {
+		duration, err := time.ParseDuration(offset)
+		if err != nil {
+			log.Fatalf("Invalid reminder offset: %s", err.Error())
+		}
+		offsets = append(offsets, duration)
+	}
+	interval := defaultReminderInterval
+	if botConfig.ReminderInterval != "" {
+		interval, err = time.ParseDuration(botConfig.ReminderInterval)
+		if err != nil {
+			log.Fatalf("Invalid reminder interval: %s", err.Error())
+		}
+	}
+	mybot.scheduler = NewScheduler(storage, tbot, offsets, interval, mybot.locale)
+
+	// Handlers are only registered here, before the bot starts: telebot does
+	// not lock its handlers. Buttons are static and their data tells what they
+	// act on, eg: projectPickBtn carries the project id.
 
 	mybot.bot.Handle("/start", func(m *tb.Message) {
 		mybot.bot.Send(m.Chat, fmt.Sprintf(`This is a bot for manage tasks.`))
 	})
 
-	mybot.bot.Handle("/create_task", func(m *tb.Message) {
-		mybot.createTask(m)
+	mybot.bot.Handle("/create_task", mybot.requires(permEditTasks, mybot.createTask))
+
+	mybot.bot.Handle("/create_project", mybot.requires(permManageProject, mybot.createProject))
+
+	mybot.bot.Handle("/list_tasks", mybot.requires(permViewTasks, mybot.handleListTask))
+
+	mybot.bot.Handle("/list_projects", mybot.requires(permViewTasks, mybot.handleListProjects))
+
+	mybot.bot.Handle("/set_default_project", mybot.requires(permManageProject, mybot.handleSetDefaultProject))
+
+	mybot.bot.Handle("/current_project", mybot.requires(permViewTasks, mybot.handleCurrentProject))
+
+	mybot.bot.Handle("/share_project", mybot.requires(permManageProject, mybot.handleShareProject))
+	mybot.bot.Handle("/project", mybot.requires(permViewTasks, mybot.handleProject))
+	mybot.bot.Handle(&projectDeleteBtn, mybot.handleProjectDelete)
+	mybot.bot.Handle(&projectPickBtn, mybot.handleProjectPick)
+
+	mybot.bot.Handle("/join_project", mybot.requires(permManageProject, mybot.handleJoinProject))
+
+	mybot.bot.Handle(tb.OnText, func(m *tb.Message) {
+		mybot.handleText(m)
 	})
 
-	mybot.bot.Handle("/create_project", func(m *tb.Message) {
-		mybot.createProject(m)
+	mybot.bot.Handle("/cancel", func(m *tb.Message) {
+		mybot.handleCancel(m)
 	})
 
-	mybot.bot.Handle("/list_tasks", func(m *tb.Message) {
-		mybot.handleListTask(m)
+	mybot.bot.Handle(&wizardAssigneeBtn, func(c *tb.Callback) {
+		mybot.handleWizardAssignee(c)
 	})
 
-	mybot.bot.Handle("/list_projects", func(m *tb.Message) {
-		mybot.handleListProjects(m)
+	mybot.bot.Handle(&wizardDeadlineBtn, func(c *tb.Callback) {
+		mybot.handleWizardDeadline(c)
 	})
 
-	mybot.bot.Handle("/set_default_project", func(m *tb.Message) {
-		mybot.handleSetDefaultProject(m)
+	mybot.bot.Handle(&wizardCalendarBtn, func(c *tb.Callback) {
+		mybot.handleWizardCalendar(c)
 	})
 
-	mybot.bot.Handle("/current_project", func(m *tb.Message) {
-		mybot.handleCurrentProject(m)
+	mybot.bot.Handle(&wizardSkipBtn, func(c *tb.Callback) {
+		mybot.handleWizardSkip(c)
 	})
 
-	mybot.bot.Handle(tb.OnText, func(m *tb.Message) {
-		mybot.handleText(m)
+	mybot.bot.Handle(&wizardConfirmBtn, func(c *tb.Callback) {
+		mybot.handleWizardConfirm(c)
 	})
 
-	mybot.bot.Handle("/assign", func(m *tb.Message) {
-		mybot.handleAssignTask(m)
+	mybot.bot.Handle(&wizardIgnoreBtn, func(c *tb.Callback) {
+		mybot.bot.Respond(c, &tb.CallbackResponse{})
 	})
 
-	mybot.bot.Handle("/set_deadline", func(m *tb.Message) {
-		mybot.handleSetDeadline(m)
+	mybot.bot.Handle("/assign", mybot.requires(permEditTasks, mybot.handleAssignTask))
+
+	mybot.bot.Handle("/unassign", mybot.requires(permEditTasks, mybot.handleUnassign))
+
+	mybot.bot.Handle("/watch", mybot.requires(permViewTasks, mybot.handleWatch))
+
+	mybot.bot.Handle("/unwatch", mybot.requires(permViewTasks, mybot.handleUnwatch))
+
+	mybot.bot.Handle("/set_deadline", mybot.requires(permEditTasks, mybot.handleSetDeadline))
+
+	mybot.bot.Handle("/timezone", mybot.requires(permManageChat, mybot.handleTimeZone))
+
+	mybot.bot.Handle("/date_order", mybot.requires(permManageChat, mybot.handleDateOrder))
+
+	mybot.bot.Handle("/digest", mybot.requires(permViewTasks, mybot.handleDigest))
+
+	mybot.bot.Handle("/repeat", mybot.requires(permEditTasks, mybot.handleRepeat))
+
+	mybot.bot.Handle("/workflow", mybot.requires(permManageProject, mybot.handleWorkflow))
+
+	mybot.bot.Handle(&statusSetBtn, func(c *tb.Callback) {
+		mybot.handleStatusButton(c)
 	})
 
-	mybot.bot.Handle("/set_status", func(m *tb.Message) {
-		mybot.handleSetStatus(m)
+	mybot.bot.Handle("/board", mybot.requires(permViewTasks, mybot.handleBoard))
+	mybot.bot.Handle("/project_stats", mybot.requires(permViewTasks, mybot.handleProjectStats))
+
+	mybot.bot.Handle(&boardRefreshBtn, mybot.requiresCallback(permViewTasks, mybot.handleBoardRefresh))
+
+	mybot.bot.Handle(&boardImageBtn, mybot.requiresCallback(permViewTasks, mybot.handleBoardImage))
+
+	mybot.bot.Handle(&boardCardBtn, mybot.requiresCallback(permViewTasks, mybot.handleBoardCard))
+
+	mybot.bot.Handle(&boardMoveBtn, func(c *tb.Callback) {
+		mybot.handleBoardMove(c)
 	})
 
-	mybot.bot.Handle("/discuss", func(m *tb.Message) {
-		// TODO: complete this function
+	mybot.bot.Handle(&taskPageBtn, mybot.requiresCallback(permViewTasks, mybot.handleTaskPage))
+
+	mybot.bot.Handle(&taskFilterBtn, mybot.requiresCallback(permViewTasks, mybot.handleTaskFilter))
+
+	mybot.bot.Handle(&taskActionBtn, func(c *tb.Callback) {
+		mybot.handleTaskAction(c)
 	})
 
+	mybot.bot.Handle("/set_status", mybot.requires(permEditTasks, mybot.handleSetStatus))
+
+	mybot.bot.Handle("/discuss", mybot.requires(permEditTasks, mybot.handleDiscuss))
+
+	// the pin belongs to the chat, handlePin checks the roles in the chat
 	mybot.bot.Handle("/pin", func(m *tb.Message) {
 		mybot.handlePin(m)
 	})
@@ -116,23 +301,126 @@ func main() {
 	// 	mybot.handleListTaskByStatus(m)
 	// })
 
-	mybot.bot.Handle("/listTaskByAssignee", func(m *tb.Message) {
-		mybot.handleListTaskByAssignee(m)
+	mybot.bot.Handle("/listTaskByAssignee", mybot.requires(permViewTasks, mybot.handleListTaskByAssignee))
+
+	mybot.bot.Handle("/mine", mybot.requires(permViewTasks, mybot.handleMyList))
+
+	// the dashboard is in a private chat, so its buttons check the roles of the
+	// presser in the chat of each task themselves: dashboardTask only gives the
+	// tasks assigned to them and handleDashboardStatus checks permEditTasks
+	mybot.bot.Handle(&dashTaskBtn, mybot.handleDashboardTask)
+
+	mybot.bot.Handle(&dashStatusBtn, mybot.handleDashboardStatus)
+
+	mybot.bot.Handle("/project_key", mybot.requires(permManageProject, mybot.handleProjectKey))
+
+	mybot.bot.Handle("/detail", mybot.requires(permViewTasks, mybot.handleDetail))
+
+	mybot.bot.Handle("/history", mybot.requires(permViewTasks, mybot.handleHistory))
+
+	mybot.bot.Handle("/activity", mybot.requires(permViewTasks, mybot.handleActivity))
+
+	mybot.bot.Handle("/edit", mybot.requires(permEditTasks, mybot.handleEdit))
+
+	mybot.bot.Handle("/move", mybot.requires(permEditTasks, mybot.handleMove))
+
+	mybot.bot.Handle(&taskMoveBtn, func(c *tb.Callback) {
+		mybot.handleMoveButton(c)
+	})
+
+	mybot.bot.Handle("/priority", mybot.requires(permEditTasks, mybot.handlePriority))
+
+	mybot.bot.Handle(&taskPriorityBtn, func(c *tb.Callback) {
+		mybot.handlePriorityButton(c)
 	})
 
-	mybot.bot.Handle("/mine", func(m *tb.Message) {
-		mybot.handleMyList(m)
+	mybot.bot.Handle("/label", mybot.requires(permEditTasks, mybot.handleLabel))
+
+	mybot.bot.Handle("/subtask", mybot.requires(permEditTasks, mybot.handleSubtask))
+
+	mybot.bot.Handle("/depends", mybot.requires(permEditTasks, mybot.handleDepends))
+
+	mybot.bot.Handle("/unblock", mybot.requires(permEditTasks, mybot.handleUnblock))
+
+	mybot.bot.Handle("/checklist", mybot.requires(permEditTasks, mybot.handleChecklist))
+
+	mybot.bot.Handle(&checklistBtn, func(c *tb.Callback) {
+		mybot.handleChecklistButton(c)
 	})
 
+	mybot.bot.Handle(&taskUnlabelBtn, func(c *tb.Callback) {
+		mybot.handleUnlabelButton(c)
+	})
+
+	mybot.bot.Handle("/delete", mybot.requires(permDeleteTasks, mybot.handleDelete))
+
+	mybot.bot.Handle("/undo", mybot.requires(permDeleteTasks, mybot.handleUndo))
+
+	mybot.bot.Handle("/restore", mybot.requires(permDeleteTasks, mybot.handleRestore))
+
+	mybot.bot.Handle(&restoreBtn, func(c *tb.Callback) {
+		mybot.handleRestoreButton(c)
+	})
+
+	mybot.bot.Handle(tb.OnPhoto, func(m *tb.Message) {
+		mybot.handleAttachment(m)
+	})
+
+	mybot.bot.Handle(tb.OnDocument, func(m *tb.Message) {
+		mybot.handleAttachment(m)
+	})
+
+	mybot.bot.Handle(&attachmentBtn, mybot.requiresCallback(permViewTasks, mybot.handleAttachmentButton))
+
+	mybot.bot.Handle("/role", mybot.requires(permManageRoles, mybot.handleRole))
+
+	mybot.bot.Handle("/roles", mybot.requires(permViewTasks, mybot.handleRoles))
+
+	// reminders are also sent to the private chat of assignees, so their
+	// buttons check permEditTasks in the chat and project of the task
+	mybot.bot.Handle(&reminderSnoozeBtn, func(c *tb.Callback) {
+		mybot.handleReminderSnooze(c)
+	})
+
+	mybot.bot.Handle(&reminderDoneBtn, func(c *tb.Callback) {
+		mybot.handleReminderDone(c)
+	})
+
+	go mybot.scheduler.Run(nil)
+	go mybot.emptyTrash(trashPurgeInterval)
+	go mybot.runDigests(digestInterval)
 	mybot.bot.Start()
 }
 
+//recordUser remember who sent an update so they can be found by username
+func recordUser(storage *TaskStorage, upd *tb.Update) {
+	var user *tb.User
+	switch {
+	case upd.Message != nil:
+		user = upd.Message.Sender
+	case upd.Callback != nil:
+		user = upd.Callback.Sender
+	}
+	if user == nil {
+		return
+	}
+	storage.StoreUser(UserDB{
+		ID:        user.ID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+	})
+}
+
 func (b Bot) saveProject(projectTitle string, m *tb.Message) {
 	newProject := Project{
-		Title:   projectTitle,
-		Creator: m.Sender.Username,
+		Title:     projectTitle,
+		CreatorID: m.Sender.ID,
+		Creator:   m.Sender.Username,
+	}
+	project, err := b.storage.StoreProject(newProject, m.Chat.ID)
+	if err == nil {
+		err = b.storage.StoreRole(0, project.ID, m.Sender.ID, roleOwner)
 	}
-	err := b.storage.StoreProject(newProject)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create project: %s", err.Error()))
 	} else {
@@ -142,144 +430,123 @@ func (b Bot) saveProject(projectTitle string, m *tb.Message) {
 	}
 }
 
-func (b Bot) saveTask(m *tb.Message) {
-	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
-	entities := m.Entities
-	taskFactors := strings.Split(m.Text, "-")
-	assignee := ""
-	for _, entity := range entities {
-		if entity.Type == tb.EntityMention {
-			if entity.User != nil {
-				assignee = entity.User.Username
-			} else {
-				assignee = taskFactors[1]
-			}
-		}
-	}
-	title := taskFactors[0]
-	deadline := ""
-	if len(taskFactors) > 2 {
-		deadline = taskFactors[2]
-	}
-	log.Printf("deadline: %s", deadline)
-	newTask := Task{
-		Assigned: assignee,
-		Title:    title,
-		Deadline: deadline,
-	}
-	err := b.storage.StoreTask(newTask, defaultProject.ProjectID)
+func (b Bot) saveTask(m *tb.Message, task Task) {
+	chat := m.Chat
+	defaultProject, _ := b.storage.GetDefaultProject(chat.ID)
+	task.Status = workflowOf(b.storage, defaultProject.ProjectID).Initial()
+	task.CreatorID = m.Sender.ID
+	task.Creator = displayName(m.Sender)
+	task.Assignees = b.knownPeople(task.Assignees)
+	created, err := b.storage.StoreTask(task, defaultProject.ProjectID, chat.ID)
 	if err != nil {
-		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create task: %s", err.Error()))
+		b.bot.Send(chat, fmt.Sprintf("Cannot create task: %s", err.Error()))
 	} else {
-		b.bot.Send(m.Chat, fmt.Sprintf("Created *%s* for *%s*", title, assignee), &tb.SendOptions{
+		message := fmt.Sprintf("Created *%s* *%s* for *%s*", b.taskKey(created), task.Title, names(task.Assignees))
+		if !task.Deadline.IsZero() {
+			message += fmt.Sprintf(", due *%s*", b.locale(chat.ID).Format(task.Deadline))
+		}
+		b.sendAbout(created, chat, message, &tb.SendOptions{
 			ParseMode: tb.ModeMarkdown,
 		})
 	}
 }
 
+//saveTaskLine create a task written in the single-line syntax
+func (b Bot) saveTaskLine(line string, m *tb.Message) {
+	task, err := parseTaskLine(line, b.locale(m.Chat.ID))
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot create task: %s", err.Error()))
+		return
+	}
+	b.saveTask(m, task)
+}
+
 func (b Bot) createProject(m *tb.Message) {
 	messages := strings.Split(strings.TrimSpace(m.Text), " ")
 	if len(messages) > 1 {
 		b.saveProject(strings.Join(messages[1:], " "), m)
 	} else {
-		if len(currentCommand) == 0 {
-			currentCommand = map[string]string{}
+		if err := b.sessions.Start(m, StateCreateProject, 0); err != nil {
+			b.bot.Send(m.Chat, fmt.Sprintf("Cannot create project: %s", err.Error()))
+			return
 		}
-		currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = "create_project"
 		b.bot.Send(m.Chat, fmt.Sprintf("Project name: "))
 	}
 }
 
 func (b Bot) createTask(m *tb.Message) {
-	currentCommand = map[string]string{
-		fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID): "create_task",
-	}
 	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	line := strings.TrimSpace(m.Payload)
+	if line != "" && defaultProject.ProjectID != 0 {
+		b.saveTaskLine(line, m)
+		return
+	}
+	err := b.sessions.Start(m, StateCreateTask, 0)
+	if err == nil && line != "" {
+		// keep the line until a project is picked
+		err = b.sessions.Update(m, func(s *Session) {
+			s.Data["line"] = line
+		})
+	}
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create task: %s", err.Error()))
+		return
+	}
 	if defaultProject.ProjectID == 0 {
-		projects, err := b.storage.GetAllProjects()
+		projects, err := b.activeProjects(m.Chat.ID)
 		if err != nil {
 			b.bot.Send(m.Chat, fmt.Sprintf("Cannot get list project to set: %s", err.Error()))
 			return
 		}
-		inlineKeys := [][]tb.InlineButton{}
-		for _, project := range projects {
-			inlineBtn := tb.InlineButton{
-				Unique: strconv.Itoa(project.ID),
-				Text:   project.Title,
-			}
-			b.bot.Handle(&inlineBtn, func(c *tb.Callback) {
-				id, _ := strconv.Atoi(inlineBtn.Unique)
-				b.setDefaultProject(m.Chat.ID, id, m)
-				b.bot.Respond(c, &tb.CallbackResponse{})
-			})
-
-			inlineKeys = append(inlineKeys, []tb.InlineButton{inlineBtn})
-		}
 		b.bot.Send(m.Chat, "Which project you want to create task for? \n", &tb.ReplyMarkup{
-			InlineKeyboard: inlineKeys,
+			InlineKeyboard: projectPicker(projects),
 		})
 	} else {
 		project, _ := b.storage.GetProject(defaultProject.ProjectID)
-		b.bot.Send(m.Chat, fmt.Sprintf(`Create task for *%s*. Follow this structure:
+		b.bot.Send(m.Chat, fmt.Sprintf(`Create task for *%s*. Send the task title, or the whole task in one line:
 			Task Title (required) - @username (optional) - Deadline (optional) - Description (optional)`, project.Title), &tb.SendOptions{
 			ParseMode: tb.ModeMarkdown,
 		})
 	}
 }
 
-func (b Bot) handleListTask(m *tb.Message) {
-	message := "Which task do you want to list? \n"
-	inlineKeys := [][]tb.InlineButton{}
-	all := tb.InlineButton{
-		Unique: "all",
-		Text:   "All",
-	}
-	b.bot.Handle(&all, func(c *tb.Callback) {
-		b.handleListAllTasks(m)
-		b.bot.Respond(c, &tb.CallbackResponse{})
-	})
-	inlineKeys = append(inlineKeys, []tb.InlineButton{all})
-	notStart := tb.InlineButton{
-		Unique: "not_start",
-		Text:   "Not Started Yet",
-	}
-	inlineKeys = append(inlineKeys, []tb.InlineButton{notStart})
-	doing := tb.InlineButton{
-		Unique: "doing",
-		Text:   "Doing",
-	}
-	b.bot.Handle(&notStart, func(c *tb.Callback) {
-		b.handleListTaskByStatus(m, notStart.Unique)
-	})
-	inlineKeys = append(inlineKeys, []tb.InlineButton{doing})
-	done := tb.InlineButton{
-		Unique: "done",
-		Text:   "Done",
-	}
-	inlineKeys = append(inlineKeys, []tb.InlineButton{done})
-	byAssignee := tb.InlineButton{
-		Unique: "by_assignee",
-		Text:   "By Assignee",
-	}
-	inlineKeys = append(inlineKeys, []tb.InlineButton{byAssignee})
-	b.bot.Reply(m, message, &tb.SendOptions{
-		ReplyMarkup: &tb.ReplyMarkup{
-			InlineKeyboard: inlineKeys,
-		},
-	})
-}
-
+//handleListProjects list the projects of the chat, or the archived ones with
+///list_projects archived
 func (b Bot) handleListProjects(m *tb.Message) {
-	projects, err := b.storage.GetAllProjects()
+	projects, err := b.storage.GetChatProjects(m.Chat.ID)
 	if err != nil {
 		b.bot.Reply(m, fmt.Sprintf("Cannot get list projects: %s", err.Error()))
 	} else {
-		if len(projects) == 0 {
+		archived := strings.EqualFold(strings.TrimSpace(m.Payload), projectArchived)
+		listed, hidden := []ProjectDB{}, 0
+		for _, project := range projects {
+			if project.archived() == archived {
+				listed = append(listed, project)
+			} else if !archived {
+				hidden++
+			}
+		}
+		if len(listed) == 0 && archived {
+			b.bot.Reply(m, "There is no archived project.")
+			return
+		}
+		if len(listed) == 0 && hidden == 0 {
 			b.bot.Reply(m, "There is not a project yet.")
+			return
 		}
 		message := "Project list: \n"
-		for _, project := range projects {
-			message += fmt.Sprintf("*%s* created by _@%s_ \n", project.Title, project.Creator)
+		if archived {
+			message = "Archived projects: \n"
+		}
+		for _, project := range listed {
+			message += fmt.Sprintf("*%s* (%s) created by _@%s_", project.Title, project.Key, project.Creator)
+			if project.ChatID != m.Chat.ID {
+				message += " (shared)"
+			}
+			message += " \n"
+		}
+		if hidden > 0 {
+			message += fmt.Sprintf("%d archived, see them with /list\\_projects archived\n", hidden)
 		}
 		b.bot.Reply(m, message, &tb.SendOptions{
 			ParseMode: tb.ModeMarkdown,
@@ -288,42 +555,41 @@ func (b Bot) handleListProjects(m *tb.Message) {
 }
 
 func (b Bot) handleSetDefaultProject(m *tb.Message) {
-	projects, err := b.storage.GetAllProjects()
+	projects, err := b.activeProjects(m.Chat.ID)
 	if err != nil {
 		b.bot.Reply(m, fmt.Sprintf("Cannot get list project to set: %s", err.Error()))
 		return
 	}
-	inlineKeys := [][]tb.InlineButton{}
-	for _, project := range projects {
-		inlineBtn := tb.InlineButton{
-			Unique: strconv.Itoa(project.ID),
-			Text:   project.Title,
-		}
-		b.bot.Handle(&inlineBtn, func(c *tb.Callback) {
-			id, _ := strconv.Atoi(inlineBtn.Unique)
-			b.setDefaultProject(m.Chat.ID, id, m)
-			b.bot.Respond(c, &tb.CallbackResponse{})
-		})
-
-		inlineKeys = append(inlineKeys, []tb.InlineButton{inlineBtn})
-	}
 	b.bot.Send(m.Chat, "Which project you want to set default? \n", &tb.ReplyMarkup{
-		InlineKeyboard: inlineKeys,
+		InlineKeyboard: projectPicker(projects),
 	})
 }
 
 func (b Bot) setDefaultProject(chatID int64, projectID int, m *tb.Message) {
-	err := b.storage.StoreDefaultProject(chatID, projectID)
+	project, err := b.storage.GetChatProject(chatID, projectID)
+	if err != nil {
+		b.bot.Send(m.Chat, "This project does not belong to this chat, join it with /join_project")
+		return
+	}
+	if project.archived() {
+		b.bot.Send(m.Chat, fmt.Sprintf("*%s* is archived, restore it first with /project unarchive %s",
+			project.Title, project.Key), tb.ModeMarkdown)
+		return
+	}
+	err = b.storage.StoreDefaultProject(chatID, projectID)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set default project for this chat: %s", err.Error()))
 	} else {
-		defaultProject, _ := b.storage.GetDefaultProject(chatID)
-		project, _ := b.storage.GetProject(defaultProject.ProjectID)
-		command, exist := currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)]
-		if exist && command != "create_task" {
+		session := b.sessions.Get(m)
+		if session.State != StateCreateTask {
 			b.bot.Send(m.Chat, fmt.Sprintf("Default project for this chat now is: %s", project.Title))
+		} else if line := session.Data["line"]; line != "" {
+			b.sessions.Finish(m)
+			b.saveTaskLine(line, m)
 		} else {
-			b.bot.Send(m.Chat, fmt.Sprintf("Create task for *%s*. Task title: ", project.Title))
+			b.bot.Send(m.Chat, fmt.Sprintf("Create task for *%s*. Task title: ", project.Title), &tb.SendOptions{
+				ParseMode: tb.ModeMarkdown,
+			})
 		}
 	}
 }
@@ -340,124 +606,80 @@ func (b Bot) handleCurrentProject(m *tb.Message) {
 	}
 }
 
-func (b Bot) handleListAllTasks(m *tb.Message) {
-	tasks, err := b.storage.GetAllTasks()
-	if err != nil {
-		b.bot.Reply(m, fmt.Sprintf("Cannot get task list: %s", err.Error()))
-	} else {
-		b.bot.Reply(m, "Task list:")
-		for _, task := range tasks {
-			message := ""
-			message += fmt.Sprintf("*%d* *%s* - *%s* - *%s* - %s", task.ID,
-				task.Assigned, task.Deadline, task.Title, task.Status)
-
-			// inlineKeys := [][]tb.InlineButton{}
-			// assignButton := tb.InlineButton{
-			// 	Unique: strconv.Itoa(task.ID),
-			// 	Text:   "👤",
-			// }
-			// b.bot.Handle(&assignButton, func(c *tb.Callback) {
-			// 	taskID, _ := strconv.Atoi(assignButton.Unique)
-			// 	b.handleAssignTask(m, taskID)
-			// })
-			// inlineKeys = append(inlineKeys, []tb.InlineButton{assignButton})
-			b.bot.Send(m.Chat, message, &tb.SendOptions{
-				ParseMode: tb.ModeMarkdown,
-				// ReplyMarkup: &tb.ReplyMarkup{
-				// 	InlineKeyboard: inlineKeys,
-				// },
-			})
-		}
-	}
-}
-
-func (b Bot) sendTasks(taskType string, m *tb.Message, tasks []TaskDB) {
-	if len(tasks) == 0 {
-		b.bot.Reply(m, fmt.Sprintf("There is no *%s* task for show", taskType), &tb.SendOptions{
-			ParseMode: tb.ModeMarkdown,
-		})
+//handleMyList list the open tasks assigned to the sender in this chat,
+//or show their dashboard in a private chat
+func (b Bot) handleMyList(m *tb.Message) {
+	if m.Chat.Type == tb.ChatPrivate {
+		b.showDashboard(m, nil)
+		return
 	}
-}
-
-func (b Bot) handleListTaskByStatus(m *tb.Message, status string) {
-	tasks, err := b.storage.GetTaskByStatus(status)
-	if err != nil {
-		b.bot.Reply(m, fmt.Sprintf("Cannot get *%s* tasks: %s", status, err.Error()), &tb.SendOptions{
-			ParseMode: tb.ModeMarkdown,
-		})
+	scope, err := b.chatScope(m.Chat.ID)
+	var tasks []TaskDB
+	if err == nil {
+		tasks, err = b.assignedTasks(m.Sender.ID, scope)
 	}
-	b.sendTasks(status, m, tasks)
-}
-
-func (b Bot) handleListTaskByAssignee(m *tb.Message) {
-
-}
-
-func (b Bot) handleMyList(m *tb.Message) {
-	telegramID := m.Sender.Username
-	log.Printf(m.Sender.Username)
-	tasks, err := b.storage.GetTaskByAssignee("@" + telegramID)
 	if err != nil {
 		b.bot.Reply(m, fmt.Sprintf("Cannot get your task list: %s", err.Error()))
 	} else {
 		message := "Your task list: \n"
+		locale := b.locale(m.Chat.ID)
 		for _, task := range tasks {
-			message += fmt.Sprintf("*%s* - *%s* \n", task.Title, task.Deadline)
+			message += fmt.Sprintf("%s *%s* - *%s* \n", b.taskKey(task), task.Title, locale.formatDeadline(task))
 		}
-		b.bot.Reply(m, message, &tb.SendOptions{
+		sent, err := b.bot.Reply(m, message, &tb.SendOptions{
 			ParseMode: tb.ModeMarkdown,
 		})
+		if err == nil {
+			b.rememberTasks(sent, tasks...)
+		}
 	}
 }
 
 func (b Bot) handleAssignTask(m *tb.Message) {
-	log.Printf("Assign")
-	if len(currentCommand) == 0 {
-		currentCommand = map[string]string{}
-	}
-	currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = "assign_task"
-	if !m.IsReply() {
-		log.Printf("Not reply anything")
-		b.bot.Reply(m, "Which task you want to assign task for? Send taskID and @mention an user to assign.")
+	if !m.IsReply() && m.Payload != "" {
+		task, _, err := b.targetTask(m)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot get task to assign: %s", err.Error()))
+			return
+		}
+		b.assignTask(task.ID, m)
+	} else if !m.IsReply() {
+		if err := b.sessions.Start(m, StateAssignTask, 0); err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot assign task: %s", err.Error()))
+			return
+		}
+		b.bot.Reply(m, "Which task you want to assign task for? Send its id or key and @mention an user to assign.")
 	} else {
-		task := m.ReplyTo
-		taskID, err := strconv.Atoi(strings.Split(task.Text, " ")[0])
+		task, err := b.repliedTask(m)
 		if err != nil {
-			b.bot.Reply(m, "Cannot get task to assign")
+			b.bot.Reply(m, fmt.Sprintf("Cannot get task to assign: %s", err.Error()))
+			return
 		}
-		b.assignTask(taskID, m)
+		b.assignTask(task.ID, m)
 	}
-	// self.bot.Send(m.Chat, fmt.Sprintf("Who do you want to assign? @mention an user to assign."))
 }
 
-func (b Bot) assignTask(currentTask int, m *tb.Message) {
-	_, exist := currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)]
-	if exist {
-		currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = ""
-	}
-	task, err := b.storage.GetTask(currentTask)
+func (b Bot) assignTask(taskID int, m *tb.Message) {
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot assign task: %s", err.Error()))
 		return
 	}
-	entities := m.Entities
-	assignee := ""
-	for _, entity := range entities {
-		if entity.Type == tb.EntityMention || entity.Type == tb.EntityTMention {
-			if entity.User != nil {
-				assignee = entity.User.Username
-			} else {
-				assignee = m.Payload
-			}
-		}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
 	}
-	task.Assigned = assignee
-	err = b.storage.UpdateTask(task)
+	people := b.mentionedPeople(m)
+	if len(people) == 0 {
+		b.bot.Reply(m, "Cannot assign task: @mention the users to assign")
+		return
+	}
+	task.Assignees = addPeople(task.Assignees, people...)
+	err = b.updateTask(task, m)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot assigntask: %s", err.Error()))
 		return
 	}
-	b.bot.Send(m.Chat, fmt.Sprintf("Task *%s* is assigned to *%s* successfully", task.Title, assignee), &tb.SendOptions{
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* is assigned to *%s* successfully", task.Title, names(task.Assignees)), &tb.SendOptions{
 		ParseMode: tb.ModeMarkdown,
 	})
 }
@@ -465,6 +687,9 @@ func (b Bot) assignTask(currentTask int, m *tb.Message) {
 func (b Bot) handlePin(m *tb.Message) {
 	if m.IsReply() {
 		// update pin message
+		if !b.permitted(m, 0, permManageChat) {
+			return
+		}
 		pinMessage := m.ReplyTo
 		err := b.storage.UpdatePinMessage(pinMessage.Text, m.Chat.ID)
 		log.Printf("Error: %+v", err)
@@ -475,6 +700,9 @@ func (b Bot) handlePin(m *tb.Message) {
 		}
 	} else {
 		// show pin message
+		if !b.permitted(m, 0, permViewTasks) {
+			return
+		}
 		pinMessage, err := b.storage.GetPinMessage(m.Chat.ID)
 		log.Printf("Pin message: %s", pinMessage)
 		if err != nil {
@@ -489,51 +717,92 @@ func (b Bot) handlePin(m *tb.Message) {
 	}
 }
 
-func (b Bot) setDeadline(taskID int, deadline string, m *tb.Message) {
-	task, err := b.storage.GetTask(taskID)
+func (b Bot) setDeadline(taskID int, text string, m *tb.Message) {
+	locale := b.locale(m.Chat.ID)
+	var deadline time.Time
+	if text != "none" {
+		var err error
+		deadline, err = locale.Parse(text)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot set task deadline: %s", err.Error()))
+			return
+		}
+	}
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set task deadline: %s", err.Error()))
 		return
 	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
 	task.Deadline = deadline
-	err = b.storage.UpdateTask(task)
+	task.DeadlineText = ""
+	err = b.updateTask(task, m)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set task deadline: %s", err.Error()))
 		return
 	}
-	b.bot.Send(m.Chat, fmt.Sprintf("Task *%s* deadline set to *%s* successfully", task.Title, deadline), &tb.SendOptions{
+	if deadline.IsZero() {
+		b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* deadline removed", task.Title), &tb.SendOptions{
+			ParseMode: tb.ModeMarkdown,
+		})
+		return
+	}
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* deadline set to *%s* successfully", task.Title, locale.Format(deadline)), &tb.SendOptions{
 		ParseMode: tb.ModeMarkdown,
 	})
 }
 
 func (b Bot) setStatus(taskID int, status string, m *tb.Message) {
-	task, err := b.storage.GetTask(currentTask)
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set status task: %s", err.Error()))
 		return
 	}
-	task.Status = status
-	err = b.storage.UpdateTask(task)
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	workflow := workflowOf(b.storage, task.ProjectID)
+	if !workflow.Has(status) || !contains(workflow.Next(task.Status), status) {
+		b.bot.Send(m.Chat, fmt.Sprintf("Task *%s* cannot move from *%s* to *%s*", task.Title,
+			workflow.Normalize(task.Status), status), tb.ModeMarkdown)
+		b.askStatus(task, m)
+		return
+	}
+	if blocked := b.blockedMove(task, status); blocked != "" {
+		b.bot.Send(m.Chat, blocked)
+		return
+	}
+	task.moveTo(status, displayName(m.Sender), time.Now())
+	err = b.updateTask(task, m)
 	if err != nil {
 		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set status task: %s", err.Error()))
 		return
 	}
-	b.bot.Send(m.Chat, fmt.Sprintf("Task *%s* status set to *%s* successfully", task.Title, status), &tb.SendOptions{
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* status set to *%s* successfully", task.Title, status), &tb.SendOptions{
 		ParseMode: tb.ModeMarkdown,
 	})
+	b.recur(task, m.Chat)
+	b.completeParent(task, m)
+	b.unblockDependents(task, m)
 }
 
 func (b Bot) handleSetDeadline(m *tb.Message) {
 	if !m.IsReply() {
 		b.bot.Reply(m, fmt.Sprintf("You should reply to a task to set deadline"))
 	} else {
-		task := m.ReplyTo
-		taskID, err := strconv.Atoi(strings.Split(task.Text, " ")[0])
+		task, err := b.repliedTask(m)
 		if err != nil {
-			b.bot.Reply(m, fmt.Sprintf("Cannot get task ID to set deadline to"))
+			b.bot.Reply(m, fmt.Sprintf("Cannot get task to set deadline to: %s", err.Error()))
+			return
+		}
+		deadline := strings.TrimSpace(m.Payload)
+		if deadline == "" {
+			b.bot.Reply(m, "Which deadline? eg: /set_deadline tomorrow 5pm, or /set_deadline none")
+			return
 		}
-		deadline := strings.Split(m.Text, " ")[1]
-		b.setDeadline(taskID, deadline, m)
+		b.setDeadline(task.ID, deadline, m)
 	}
 }
 
@@ -541,32 +810,78 @@ func (b Bot) handleSetStatus(m *tb.Message) {
 	if !m.IsReply() {
 		b.bot.Reply(m, fmt.Sprintf("You should reply to a task to set status"))
 	} else {
-		task := m.ReplyTo
-		taskID, err := strconv.Atoi(strings.Split(task.Text, " ")[0])
+		task, err := b.repliedTask(m)
 		if err != nil {
-			b.bot.Reply(m, fmt.Sprintf("Cannot get task ID to set status to"))
+			b.bot.Reply(m, fmt.Sprintf("Cannot get task to set status to: %s", err.Error()))
+			return
+		}
+		if !b.permitted(m, task.ProjectID, permEditTasks) {
+			return
 		}
-		status := strings.Split(m.Text, " ")[1]
-		b.setStatus(taskID, status, m)
+		status := strings.ToLower(strings.TrimSpace(m.Payload))
+		if status == "" {
+			b.askStatus(task, m)
+			return
+		}
+		b.setStatus(task.ID, status, m)
 	}
 }
 
 func (b Bot) handleText(m *tb.Message) {
-	command, exist := currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)]
-	if !exist {
+	session := b.sessions.Get(m)
+	if session.State == StateIdle {
+		b.commentOnReply(m)
 		return
 	}
-	log.Printf("Command: %s", command)
-	switch command {
-	case "create_task":
-		b.saveTask(m)
-		currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = ""
-	case "create_project":
+	switch session.State {
+	case StateCreateTask, StateTaskAssignee, StateTaskDeadline, StateTaskDescription, StateTaskConfirm:
+		b.handleWizardText(m, session)
+	case StateCreateProject:
+		b.sessions.Finish(m)
 		b.saveProject(m.Text, m)
-		currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = ""
-	case "assign_task":
-		log.Printf("Current task: %+v", currentTask)
-		b.assignTask(currentTask, m)
-		currentCommand[fmt.Sprintf("%d_%d", m.Sender.ID, m.Chat.ID)] = ""
+	case StateAssignTask:
+		taskID := session.TaskID
+		if taskID == 0 {
+			fields := strings.Fields(m.Text)
+			if len(fields) == 0 {
+				b.bot.Reply(m, "Send the task id or key and @mention an user to assign, or /cancel.")
+				return
+			}
+			task, err := b.findTask(m.Chat.ID, fields[0])
+			if err != nil {
+				b.bot.Reply(m, fmt.Sprintf("Cannot assign task: %s. Send the task id or key and @mention an user, or /cancel.", err.Error()))
+				return
+			}
+			taskID = task.ID
+		}
+		b.sessions.Finish(m)
+		b.assignTask(taskID, m)
+	case StateSetDeadline:
+		b.sessions.Finish(m)
+		b.setDeadline(session.TaskID, strings.TrimSpace(m.Text), m)
+	case StateEditTitle, StateEditDescription:
+		b.sessions.Finish(m)
+		b.editTaskText(session.State, session.TaskID, m.Text, m)
+	case StateSetLabels:
+		b.sessions.Finish(m)
+		b.labelTask(session.TaskID, m.Text, m)
+	case StateAddSubtask:
+		b.sessions.Finish(m)
+		b.saveSubtask(session.TaskID, m.Text, m)
+	case StateAddChecklistItem:
+		b.sessions.Finish(m)
+		b.addChecklistItems(session.TaskID, m.Text, m)
+	}
+}
+
+func (b Bot) handleCancel(m *tb.Message) {
+	if b.sessions.Get(m).State == StateIdle {
+		b.bot.Reply(m, "There is nothing to cancel")
+		return
+	}
+	if err := b.sessions.Finish(m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot cancel: %s", err.Error()))
+		return
 	}
+	b.bot.Reply(m, "Cancelled")
 }
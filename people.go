@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+//is tell whether two people are the same user, by id when both are known
+func (p Person) is(other Person) bool {
+	if p.ID != 0 && other.ID != 0 {
+		return p.ID == other.ID
+	}
+	return strings.EqualFold(p.Name, other.Name)
+}
+
+//personOf is a telegram user as a task keeps them
+func personOf(user *tb.User) Person {
+	name := user.Username
+	if name == "" {
+		name = user.FirstName
+	}
+	return Person{ID: user.ID, Name: name}
+}
+
+//includes tell whether a list of people has someone
+func includes(people []Person, person Person) bool {
+	for _, other := range people {
+		if other.is(person) {
+			return true
+		}
+	}
+	return false
+}
+
+//addPeople add to a list the people it does not have yet
+func addPeople(people []Person, added ...Person) []Person {
+	for _, person := range added {
+		if !includes(people, person) {
+			people = append(people, person)
+		}
+	}
+	return people
+}
+
+//removePeople remove people from a list
+func removePeople(people []Person, removed ...Person) []Person {
+	kept := []Person{}
+	for _, person := range people {
+		if !includes(removed, person) {
+			kept = append(kept, person)
+		}
+	}
+	return kept
+}
+
+//names render a list of people, eg: "halink0803, an"
+func names(people []Person) string {
+	list := []string{}
+	for _, person := range people {
+		list = append(list, person.Name)
+	}
+	return strings.Join(list, ", ")
+}
+
+//encodePeople write a list of people in a session field
+func encodePeople(people []Person) string {
+	if len(people) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(people)
+	return string(data)
+}
+
+//decodePeople read a list of people from a session field
+func decodePeople(data string) []Person {
+	people := []Person{}
+	json.Unmarshal([]byte(data), &people)
+	return people
+}
+
+// personMatcher matches tasks having someone in a list of people
+type personMatcher struct {
+	person Person
+}
+
+func (p personMatcher) MatchField(v interface{}) (bool, error) {
+	people, ok := v.([]Person)
+	if !ok {
+		return false, nil
+	}
+	return includes(people, p.person), nil
+}
+
+//hasPerson match tasks having someone among the people of a field, eg: Assignees
+func hasPerson(field string, person Person) q.Matcher {
+	return q.NewFieldMatcher(field, personMatcher{person})
+}
+
+//knownPerson fill the id of someone from their username when the bot knows them
+func (b Bot) knownPerson(person Person) Person {
+	if person.ID == 0 && person.Name != "" {
+		if user, err := b.storage.GetUserByUsername(person.Name); err == nil {
+			person.ID = user.ID
+		}
+	}
+	return person
+}
+
+//knownPeople fill the id of people the bot knows
+func (b Bot) knownPeople(people []Person) []Person {
+	known := []Person{}
+	for _, person := range people {
+		known = append(known, b.knownPerson(person))
+	}
+	return known
+}
+
+//mentionedPeople return every user a message mentions
+func (b Bot) mentionedPeople(m *tb.Message) []Person {
+	people := []Person{}
+	for _, entity := range m.Entities {
+		if entity.Type == tb.EntityTMention && entity.User != nil {
+			people = addPeople(people, personOf(entity.User))
+		}
+	}
+	for _, word := range strings.Fields(m.Text) {
+		if mentionPattern.MatchString(word) {
+			people = addPeople(people, b.knownPerson(Person{Name: strings.TrimPrefix(word, "@")}))
+		}
+	}
+	return people
+}
+
+//notifyPeople send a message about a task to its assignees and watchers,
+//but the one who made the change
+func (b Bot) notifyPeople(task TaskDB, actor Actor, message string) {
+	for _, person := range addPeople(append([]Person{}, task.Assignees...), task.Watchers...) {
+		if person.ID == 0 || person.ID == actor.ID {
+			continue
+		}
+		// users have to start a private chat with the bot first
+		b.bot.Send(&tb.User{ID: person.ID}, message, tb.ModeMarkdown)
+	}
+}
+
+//updateTask save the changes of a task made from a message and tell them
+//to everyone on the task
+func (b Bot) updateTask(task TaskDB, m *tb.Message) error {
+	old, err := b.storage.GetTask(task.ID)
+	if err != nil {
+		return err
+	}
+	actor := actorOf(m)
+	if err := b.storage.UpdateTask(task, actor); err != nil {
+		return err
+	}
+	changes := taskChanges(old, task)
+	if len(changes) == 0 {
+		return nil
+	}
+	locale := b.locale(m.Chat.ID)
+	message := fmt.Sprintf("🔔 %s changed *%s* *%s*:\n", escapeMarkdown(actor.Name), b.taskKey(task), escapeMarkdown(task.Title))
+	for _, change := range changes {
+		message += activityText(change, locale) + "\n"
+	}
+	b.notifyPeople(task, actor, message)
+	return nil
+}
+
+//changePeople add or remove people of a task and tell what it has now
+func (b Bot) changePeople(task TaskDB, m *tb.Message, change func(*TaskDB)) {
+	change(&task)
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot change task: %s", err.Error()))
+		return
+	}
+	message := fmt.Sprintf("*%s* *%s* is assigned to %s", b.taskKey(task), escapeMarkdown(task.Title), orNone(escapeMarkdown(names(task.Assignees))))
+	if len(task.Watchers) > 0 {
+		message += fmt.Sprintf(", watched by %s", escapeMarkdown(names(task.Watchers)))
+	}
+	b.sendAbout(task, m.Chat, message, tb.ModeMarkdown)
+}
+
+//handleUnassign remove the mentioned assignees of a task, or all of them
+func (b Bot) handleUnassign(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot unassign task: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	people := b.mentionedPeople(m)
+	b.changePeople(task, m, func(task *TaskDB) {
+		if len(people) == 0 {
+			task.Assignees = nil
+			return
+		}
+		task.Assignees = removePeople(task.Assignees, people...)
+	})
+}
+
+//watchers return who a watch command is about: the sender, or the mentioned
+//users. Viewers can only watch themselves, changing what others are told
+//needs permEditTasks.
+func (b Bot) watchers(m *tb.Message, task TaskDB) ([]Person, bool) {
+	people := b.mentionedPeople(m)
+	if len(people) == 0 {
+		people = []Person{personOf(m.Sender)}
+	}
+	permission := permViewTasks
+	if len(removePeople(people, personOf(m.Sender))) > 0 {
+		permission = permEditTasks
+	}
+	return people, b.permitted(m, task.ProjectID, permission)
+}
+
+//handleWatch make the sender, or the mentioned users, watch a task
+func (b Bot) handleWatch(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot watch task: %s", err.Error()))
+		return
+	}
+	people, ok := b.watchers(m, task)
+	if !ok {
+		return
+	}
+	b.changePeople(task, m, func(task *TaskDB) {
+		task.Watchers = addPeople(task.Watchers, people...)
+	})
+}
+
+//handleUnwatch stop the sender, or the mentioned users, watching a task
+func (b Bot) handleUnwatch(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot unwatch task: %s", err.Error()))
+		return
+	}
+	people, ok := b.watchers(m, task)
+	if !ok {
+		return
+	}
+	b.changePeople(task, m, func(task *TaskDB) {
+		task.Watchers = removePeople(task.Watchers, people...)
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// roles from the least to the most trusted
+const (
+	roleViewer     = "viewer"
+	roleMember     = "member"
+	roleMaintainer = "maintainer"
+	roleOwner      = "owner"
+)
+
+var roles = []string{roleViewer, roleMember, roleMaintainer, roleOwner}
+
+// defaultRole is the role of users nobody gave a role to
+const defaultRole = roleMember
+
+var errBadRole = errors.New("mention an user and give one of viewer, member, maintainer or owner, add project to only give it in the current project, eg: /role @halink0803 maintainer")
+
+//Permission is what a handler needs the sender to be allowed to do
+type Permission string
+
+const (
+	permViewTasks     Permission = "see tasks"
+	permEditTasks     Permission = "change tasks"
+	permDeleteTasks   Permission = "delete tasks"
+	permManageProject Permission = "manage projects"
+	permManageChat    Permission = "change the settings of this chat"
+	permManageRoles   Permission = "give roles"
+)
+
+// permissionRoles is the least role having each permission
+var permissionRoles = map[Permission]string{
+	permViewTasks:     roleViewer,
+	permEditTasks:     roleMember,
+	permDeleteTasks:   roleMaintainer,
+	permManageProject: roleMaintainer,
+	permManageChat:    roleMaintainer,
+	permManageRoles:   roleMaintainer,
+}
+
+//roleRank order roles, unknown roles rank below viewer
+func roleRank(role string) int {
+	for i, name := range roles {
+		if name == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+//seedChatRoles give roles to the administrators of a group the first time
+//the bot checks a permission there: the creator is owner, admins are maintainers
+//The chat is only marked seeded once the admins got their roles, so a failure
+//is tried again on the next message; owners give roles with /role afterwards.
+func (b Bot) seedChatRoles(chat *tb.Chat) {
+	settings, err := b.storage.GetChatSettings(chat.ID)
+	if err != nil || settings.RolesSeeded {
+		return
+	}
+	given, err := b.storage.GetRoles(chat.ID, 0)
+	if err != nil {
+		return
+	}
+	if len(given) == 0 {
+		admins, err := b.bot.AdminsOf(chat)
+		if err != nil {
+			log.Printf("Cannot get admins of chat %d: %s", chat.ID, err.Error())
+			return
+		}
+		for _, admin := range admins {
+			role := roleMaintainer
+			if admin.Role == tb.Creator {
+				role = roleOwner
+			}
+			if err := b.storage.StoreRole(chat.ID, 0, admin.User.ID, role); err != nil {
+				return
+			}
+		}
+	}
+	settings.ChatID = chat.ID
+	settings.RolesSeeded = true
+	b.storage.StoreChatSettings(settings)
+}
+
+//roleOf return the role of an user in a project of a chat
+//The owner of the chat owns every project, otherwise a role given in the
+//project wins over the role in the chat. Project creators own their project
+//and everybody owns their private chat with the bot.
+func (b Bot) roleOf(chat *tb.Chat, user *tb.User, projectID int) string {
+	if chat.Type == tb.ChatPrivate {
+		return roleOwner
+	}
+	b.seedChatRoles(chat)
+	chatRole, _ := b.storage.GetRole(chat.ID, 0, user.ID)
+	if chatRole.Role == roleOwner {
+		return roleOwner
+	}
+	if projectID != 0 {
+		project, err := b.storage.GetProject(projectID)
+		if err == nil && project.CreatorID != 0 && project.CreatorID == user.ID {
+			return roleOwner
+		}
+		projectRole, _ := b.storage.GetRole(0, projectID, user.ID)
+		if projectRole.Role != "" {
+			return projectRole.Role
+		}
+	}
+	if chatRole.Role != "" {
+		return chatRole.Role
+	}
+	return defaultRole
+}
+
+//allowed check whether an user has a permission in a project of a chat
+//and return the message explaining a refusal
+func (b Bot) allowed(chat *tb.Chat, user *tb.User, projectID int, permission Permission) (bool, string) {
+	needed := permissionRoles[permission]
+	role := b.roleOf(chat, user, projectID)
+	if roleRank(role) >= roleRank(needed) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("Only a %s or above can %s, you are %s here. Ask an owner with /roles", needed, permission, role)
+}
+
+//permitted check the sender of a message has a permission in a project,
+//replying when they do not
+func (b Bot) permitted(m *tb.Message, projectID int, permission Permission) bool {
+	ok, refusal := b.allowed(m.Chat, m.Sender, projectID, permission)
+	if !ok {
+		b.bot.Reply(m, refusal)
+	}
+	return ok
+}
+
+//requires guard a command with a permission in the default project of the chat
+func (b Bot) requires(permission Permission, handler func(*tb.Message)) func(*tb.Message) {
+	return func(m *tb.Message) {
+		defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+		if b.permitted(m, defaultProject.ProjectID, permission) {
+			handler(m)
+		}
+	}
+}
+
+//requiresCallback guard a button with a permission in the default project of the chat
+func (b Bot) requiresCallback(permission Permission, handler func(*tb.Callback)) func(*tb.Callback) {
+	return func(c *tb.Callback) {
+		defaultProject, _ := b.storage.GetDefaultProject(c.Message.Chat.ID)
+		ok, refusal := b.allowed(c.Message.Chat, c.Sender, defaultProject.ProjectID, permission)
+		if !ok {
+			b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+			return
+		}
+		handler(c)
+	}
+}
+
+//mentionedUser return the user a message mentions, or the sender of the replied message
+func (b Bot) mentionedUser(m *tb.Message) (*tb.User, error) {
+	for _, entity := range m.Entities {
+		if entity.Type == tb.EntityTMention && entity.User != nil {
+			return entity.User, nil
+		}
+	}
+	if mentions := mentionedUsernames(m); len(mentions) > 0 {
+		user, err := b.storage.GetUserByUsername(mentions[0])
+		if err != nil {
+			return nil, fmt.Errorf("I do not know @%s yet, they should talk to me first", mentions[0])
+		}
+		return &tb.User{ID: user.ID, Username: user.Username, FirstName: user.FirstName}, nil
+	}
+	if m.IsReply() && m.ReplyTo.Sender != nil {
+		return m.ReplyTo.Sender, nil
+	}
+	return nil, errBadRole
+}
+
+//handleRole give a role in the chat, or with "project" in the default project
+func (b Bot) handleRole(m *tb.Message) {
+	user, err := b.mentionedUser(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot give role: %s", err.Error()))
+		return
+	}
+	role, projectID := "", 0
+	for _, word := range strings.Fields(strings.ToLower(m.Payload)) {
+		switch {
+		case roleRank(word) > 0:
+			role = word
+		case word == "project":
+			defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+			if defaultProject.ProjectID == 0 {
+				b.bot.Reply(m, "Set a default project first with /set_default_project")
+				return
+			}
+			projectID = defaultProject.ProjectID
+		}
+	}
+	if role == "" {
+		b.bot.Reply(m, fmt.Sprintf("Cannot give role: %s", errBadRole.Error()))
+		return
+	}
+	own := b.roleOf(m.Chat, m.Sender, projectID)
+	if roleRank(role) > roleRank(own) || roleRank(b.roleOf(m.Chat, user, projectID)) > roleRank(own) {
+		b.bot.Reply(m, fmt.Sprintf("You are %s, you cannot give a higher role or change the role of someone above you", own))
+		return
+	}
+	chatID := m.Chat.ID
+	if projectID != 0 {
+		chatID = 0
+	}
+	if err := b.storage.StoreRole(chatID, projectID, user.ID, role); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot give role: %s", err.Error()))
+		return
+	}
+	where := "this chat"
+	if projectID != 0 {
+		project, _ := b.storage.GetProject(projectID)
+		where = project.Title
+	}
+	b.bot.Reply(m, fmt.Sprintf("%s is now %s in %s", displayName(user), role, where))
+}
+
+//displayName is the username of an user, or the first name if they have none
+func displayName(user *tb.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return user.FirstName
+}
+
+//handleRoles list the roles given in the chat and in its default project
+func (b Bot) handleRoles(m *tb.Message) {
+	if m.Chat.Type != tb.ChatPrivate {
+		b.seedChatRoles(m.Chat)
+	}
+	message := ""
+	listRoles := func(title string, chatID int64, projectID int) {
+		given, err := b.storage.GetRoles(chatID, projectID)
+		if err != nil || len(given) == 0 {
+			return
+		}
+		message += title + ":\n"
+		for _, role := range given {
+			name := fmt.Sprintf("user %d", role.UserID)
+			if user, err := b.storage.GetUser(role.UserID); err == nil {
+				name = displayName(&tb.User{Username: user.Username, FirstName: user.FirstName})
+			}
+			message += fmt.Sprintf("%s - %s\n", name, role.Role)
+		}
+	}
+	listRoles("Roles in this chat", m.Chat.ID, 0)
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	if project, err := b.storage.GetProject(defaultProject.ProjectID); err == nil {
+		listRoles(fmt.Sprintf("Roles in %s", project.Title), 0, project.ID)
+		if project.Creator != "" {
+			message += fmt.Sprintf("@%s owns %s as its creator\n", project.Creator, project.Title)
+		}
+	}
+	message += fmt.Sprintf("Everybody else is %s.", defaultRole)
+	b.bot.Reply(m, message)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// taskPriorityBtn sets the priority picked, its data is "<task id>:<priority>"
+// with an empty priority for none
+var taskPriorityBtn = tb.InlineButton{Unique: "task_priority"}
+
+// priorities from the most to the least urgent
+var priorities = []string{"P0", "P1", "P2", "P3"}
+
+// priorityNames are the words setting a priority, eg: !high
+var priorityNames = map[string]string{
+	"p0":       "P0",
+	"urgent":   "P0",
+	"critical": "P0",
+	"p1":       "P1",
+	"high":     "P1",
+	"p2":       "P2",
+	"medium":   "P2",
+	"normal":   "P2",
+	"p3":       "P3",
+	"low":      "P3",
+}
+
+var errBadPriority = errors.New("priority is P0 to P3, urgent, high, medium, low or none")
+
+//parsePriority read a priority with or without "!", eg: "!high" or "P1"
+//"none" is no priority.
+func parsePriority(text string) (string, error) {
+	text = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(text), "!"))
+	if text == "none" {
+		return "", nil
+	}
+	priority, ok := priorityNames[text]
+	if !ok {
+		return "", errBadPriority
+	}
+	return priority, nil
+}
+
+//isPriorityWord tell whether a word of the create syntax sets the priority, eg: !high
+func isPriorityWord(word string) bool {
+	if !strings.HasPrefix(word, "!") {
+		return false
+	}
+	_, ok := priorityNames[strings.ToLower(word[1:])]
+	return ok
+}
+
+//priorityRank order priorities, tasks without one come last
+func priorityRank(priority string) int {
+	for i, other := range priorities {
+		if other == priority {
+			return i
+		}
+	}
+	return len(priorities)
+}
+
+//handlePriority set the priority of a task, or pick it with buttons, eg: /priority OPS-3 high
+func (b Bot) handlePriority(m *tb.Message) {
+	task, args, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set task priority: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	if len(args) == 0 {
+		b.askPriority(task, m)
+		return
+	}
+	priority, err := parsePriority(args[0])
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set task priority: %s", err.Error()))
+		return
+	}
+	b.setPriority(task, priority, m)
+}
+
+//askPriority send a button for each priority of a task
+func (b Bot) askPriority(task TaskDB, m *tb.Message) {
+	row := []tb.InlineButton{}
+	for _, priority := range priorities {
+		row = append(row, dataButton(taskPriorityBtn, checked(priority, task.Priority == priority),
+			fmt.Sprintf("%d:%s", task.ID, priority)))
+	}
+	keys := [][]tb.InlineButton{row, {
+		dataButton(taskPriorityBtn, checked("None", task.Priority == ""), fmt.Sprintf("%d:", task.ID)),
+	}}
+	b.bot.Send(m.Chat, fmt.Sprintf("Which priority has *%s*? P0 is the most urgent", task.Title), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//setPriority change the priority of a task and tell the chat
+func (b Bot) setPriority(task TaskDB, priority string, m *tb.Message) {
+	task.Priority = priority
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot set task priority: %s", err.Error()))
+		return
+	}
+	if priority == "" {
+		b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* priority removed", task.Title), tb.ModeMarkdown)
+		return
+	}
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Task *%s* priority set to *%s*", task.Title, priority), tb.ModeMarkdown)
+}
+
+//handlePriorityButton set the priority picked
+func (b Bot) handlePriorityButton(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	if parts[1] != "" && priorityRank(parts[1]) == len(priorities) {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setPriority(task, parts[1], callbackMessage(c))
+	b.bot.Delete(c.Message)
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// shareCodeBytes is the length of the random share code of a project
+const shareCodeBytes = 5
+
+// statuses of a project, archived projects keep their tasks readable but are
+// left out of project lists and pickers
+const (
+	projectActive   = "active"
+	projectArchived = "archived"
+)
+
+// projectDeleteBtn deletes a project once confirmed, its data is the project
+// id, or empty to cancel
+var projectDeleteBtn = tb.InlineButton{Unique: "project_delete"}
+
+// projectPickBtn makes a project the default of the chat it is pressed in,
+// its data is the project id
+var projectPickBtn = tb.InlineButton{Unique: "project_pick"}
+
+const projectUsage = "Manage the current project with /project rename <title>, /project archive, /project delete, " +
+	"or another one by its id or key, eg: /project archive OPS, /project unarchive OPS"
+
+//archived tell whether a project is archived
+func (p ProjectDB) archived() bool {
+	return p.Status == projectArchived
+}
+
+//chatScope restrict a task query to the projects visible in a chat
+func (b Bot) chatScope(chatID int64) (q.Matcher, error) {
+	projects, err := b.storage.GetChatProjects(chatID)
+	if err != nil {
+		return nil, err
+	}
+	projectIDs := []int{}
+	for _, project := range projects {
+		projectIDs = append(projectIDs, project.ID)
+	}
+	return q.In("ProjectID", projectIDs), nil
+}
+
+//activeProjects get the projects of a chat that are not archived
+func (b Bot) activeProjects(chatID int64) ([]ProjectDB, error) {
+	projects, err := b.storage.GetChatProjects(chatID)
+	if err != nil {
+		return nil, err
+	}
+	active := []ProjectDB{}
+	for _, project := range projects {
+		if !project.archived() {
+			active = append(active, project)
+		}
+	}
+	return active, nil
+}
+
+//projectPicker build a button per project making it the default project
+func projectPicker(projects []ProjectDB) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	for _, project := range projects {
+		keys = append(keys, []tb.InlineButton{
+			dataButton(projectPickBtn, shorten(project.Title, maxButtonTitle), strconv.Itoa(project.ID)),
+		})
+	}
+	return keys
+}
+
+//handleProjectPick make the picked project the default of the chat
+//Changing it needs the chat settings permission, unless the sender is
+//picking the project of the task they are creating.
+func (b Bot) handleProjectPick(c *tb.Callback) {
+	m := callbackMessage(c)
+	permission := permManageChat
+	if b.sessions.Get(m).State == StateCreateTask {
+		permission = permEditTasks
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, 0, permission); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	projectID, _ := strconv.Atoi(c.Data)
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setDefaultProject(c.Message.Chat.ID, projectID, m)
+}
+
+//findProject get a project visible in a chat by its id or its key
+func (b Bot) findProject(chatID int64, ref string) (ProjectDB, error) {
+	projectID, err := strconv.Atoi(ref)
+	if err != nil {
+		project, err := b.storage.GetProjectByKey(strings.ToUpper(ref))
+		if err != nil {
+			return ProjectDB{}, fmt.Errorf("no project has the id or key %s", ref)
+		}
+		projectID = project.ID
+	}
+	project, err := b.storage.GetChatProject(chatID, projectID)
+	if err != nil {
+		return ProjectDB{}, fmt.Errorf("no project of this chat has the id or key %s", ref)
+	}
+	return project, nil
+}
+
+func newShareCode() (string, error) {
+	code := make([]byte, shareCodeBytes)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(code), nil
+}
+
+//handleShareProject let other chats join the default project with a code,
+//or stop sharing it with "off"
+func (b Bot) handleShareProject(m *tb.Message) {
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, err := b.storage.GetChatProject(m.Chat.ID, defaultProject.ProjectID)
+	if err != nil {
+		b.bot.Reply(m, "Set a default project first with /set_default_project")
+		return
+	}
+	if project.ChatID != m.Chat.ID {
+		b.bot.Reply(m, fmt.Sprintf("Only the chat that created *%s* can share it", project.Title), tb.ModeMarkdown)
+		return
+	}
+	if strings.TrimSpace(m.Payload) == "off" {
+		project.ShareCode = ""
+		if err := b.storage.UpdateProject(project); err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot stop sharing project: %s", err.Error()))
+			return
+		}
+		if err := b.storage.DeleteProjectShares(project); err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot stop sharing project: %s", err.Error()))
+			return
+		}
+		b.bot.Reply(m, fmt.Sprintf("*%s* is no longer shared with other chats", project.Title), tb.ModeMarkdown)
+		return
+	}
+	if project.ShareCode == "" {
+		project.ShareCode, err = newShareCode()
+		if err == nil {
+			err = b.storage.UpdateProject(project)
+		}
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot share project: %s", err.Error()))
+			return
+		}
+	}
+	b.bot.Reply(m, fmt.Sprintf("To work on *%s* from another chat, send there:\n`/join_project %s`\nStop sharing with /share\\_project off",
+		project.Title, project.ShareCode), tb.ModeMarkdown)
+}
+
+//handleJoinProject add a shared project to the chat and make it the default
+func (b Bot) handleJoinProject(m *tb.Message) {
+	code := strings.ToLower(strings.TrimSpace(m.Payload))
+	if code == "" {
+		b.bot.Reply(m, "Send the code given by /share_project in the chat of the project, eg: /join_project 1a2b3c4d5e")
+		return
+	}
+	project, err := b.storage.GetProjectByShareCode(code)
+	if err != nil {
+		b.bot.Reply(m, "No project is shared with this code")
+		return
+	}
+	if project.ChatID != m.Chat.ID {
+		if err := b.storage.StoreProjectShare(project.ID, m.Chat.ID); err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot join project: %s", err.Error()))
+			return
+		}
+	}
+	b.setDefaultProject(m.Chat.ID, project.ID, m)
+}
+
+//handleProject show the current project, or rename, archive, unarchive or
+//delete a project, eg: /project rename Mobile App
+func (b Bot) handleProject(m *tb.Message) {
+	args := strings.Fields(m.Payload)
+	if len(args) == 0 {
+		b.showProject(m)
+		return
+	}
+	action := strings.ToLower(args[0])
+	ref := ""
+	if len(args) > 1 && action != "rename" {
+		ref = args[1]
+	}
+	project, err := b.projectOf(m, ref)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change project: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, project.ID, permManageProject) {
+		return
+	}
+	if project.ChatID != m.Chat.ID {
+		b.bot.Reply(m, fmt.Sprintf("Only the chat that created *%s* can change it", project.Title), tb.ModeMarkdown)
+		return
+	}
+	switch action {
+	case "rename":
+		b.renameProject(project, afterWords(m.Payload, 1), m)
+	case "archive":
+		b.archiveProject(project, true, m)
+	case "unarchive":
+		b.archiveProject(project, false, m)
+	case "delete":
+		tasks, _ := b.storage.FindTasks(q.Eq("ProjectID", project.ID))
+		b.bot.Reply(m, fmt.Sprintf("Delete *%s* with its %d tasks, their comments and attachments? This cannot be undone.",
+			project.Title, len(tasks)), &tb.SendOptions{
+			ParseMode: tb.ModeMarkdown,
+			ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{
+				dataButton(projectDeleteBtn, "Yes, delete", strconv.Itoa(project.ID)),
+				dataButton(projectDeleteBtn, "No", ""),
+			}}},
+		})
+	default:
+		b.bot.Reply(m, projectUsage)
+	}
+}
+
+//projectOf get the project given by its id or key, or the default project of the chat
+func (b Bot) projectOf(m *tb.Message, ref string) (ProjectDB, error) {
+	if ref != "" {
+		return b.findProject(m.Chat.ID, ref)
+	}
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, err := b.storage.GetChatProject(m.Chat.ID, defaultProject.ProjectID)
+	if err != nil {
+		return project, errors.New("set a default project first with /set_default_project, or give the id or key of one")
+	}
+	return project, nil
+}
+
+//showProject tell about the current project and how to change it
+func (b Bot) showProject(m *tb.Message) {
+	project, err := b.projectOf(m, "")
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("%s\n%s", err.Error(), projectUsage))
+		return
+	}
+	tasks, _ := b.storage.FindTasks(q.Eq("ProjectID", project.ID))
+	message := fmt.Sprintf("*%s* (key %s), %d tasks, created by %s", project.Title, project.Key, len(tasks),
+		escapeMarkdown(project.Creator))
+	if project.ShareCode != "" {
+		message += ", shared"
+	}
+	b.bot.Reply(m, message+"\n"+escapeMarkdown(projectUsage), tb.ModeMarkdown)
+}
+
+//renameProject change the title of a project, its key stays
+func (b Bot) renameProject(project ProjectDB, title string, m *tb.Message) {
+	if title == "" {
+		b.bot.Reply(m, "Give the new title, eg: /project rename Mobile App")
+		return
+	}
+	old := project.Title
+	project.Title = title
+	if err := b.storage.UpdateProject(project); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot rename project: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Renamed *%s* to *%s*", old, project.Title), tb.ModeMarkdown)
+}
+
+//archiveProject archive or unarchive a project
+//Chats using an archived project as default get another one.
+func (b Bot) archiveProject(project ProjectDB, archive bool, m *tb.Message) {
+	if project.archived() == archive {
+		b.bot.Reply(m, fmt.Sprintf("*%s* already is %s", project.Title, projectStatus(project)), tb.ModeMarkdown)
+		return
+	}
+	project.Status = projectActive
+	if archive {
+		project.Status = projectArchived
+	}
+	if err := b.storage.UpdateProject(project); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change project: %s", err.Error()))
+		return
+	}
+	if !archive {
+		b.bot.Reply(m, fmt.Sprintf("*%s* is active again, make it the default with /set\\_default\\_project",
+			project.Title), tb.ModeMarkdown)
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Archived *%s*, its tasks stay readable. Restore it with /project unarchive %s",
+		project.Title, project.Key), tb.ModeMarkdown)
+	chats, _ := b.storage.GetChatsByDefaultProject(project.ID)
+	b.resetDefaultProjects(project, chats)
+}
+
+//projectStatus name the status of a project
+func projectStatus(project ProjectDB) string {
+	if project.archived() {
+		return projectArchived
+	}
+	return projectActive
+}
+
+//resetDefaultProjects give chats that used a project as default the first
+//other active project they have, or none, and tell them
+func (b Bot) resetDefaultProjects(project ProjectDB, chats []int64) {
+chats:
+	for _, chatID := range chats {
+		chat := &tb.Chat{ID: chatID}
+		projects, err := b.activeProjects(chatID)
+		if err == nil {
+			for _, other := range projects {
+				if other.ID == project.ID {
+					continue
+				}
+				if err := b.storage.StoreDefaultProject(chatID, other.ID); err == nil {
+					b.bot.Send(chat, fmt.Sprintf("*%s* is no longer available, the default project now is *%s*",
+						project.Title, other.Title), tb.ModeMarkdown)
+					continue chats
+				}
+			}
+		}
+		b.storage.DeleteDefaultProject(chatID)
+		b.bot.Send(chat, fmt.Sprintf("*%s* is no longer available, pick another project with /set\\_default\\_project",
+			project.Title), tb.ModeMarkdown)
+	}
+}
+
+//handleProjectDelete delete a project once confirmed
+func (b Bot) handleProjectDelete(c *tb.Callback) {
+	if c.Data == "" {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		b.bot.Delete(c.Message)
+		return
+	}
+	projectID, _ := strconv.Atoi(c.Data)
+	project, err := b.storage.GetChatProject(c.Message.Chat.ID, projectID)
+	if err != nil || project.ChatID != c.Message.Chat.ID {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This project does not exist anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, project.ID, permManageProject); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	chats, _ := b.storage.GetChatsByDefaultProject(project.ID)
+	deleted, err := b.storage.DeleteProject(project)
+	if err != nil {
+		b.bot.Edit(c.Message, fmt.Sprintf("Cannot delete project: %s", err.Error()))
+		return
+	}
+	b.bot.Edit(c.Message, fmt.Sprintf("Deleted *%s* and its %d tasks", project.Title, deleted), tb.ModeMarkdown)
+	b.resetDefaultProjects(project, chats)
+}
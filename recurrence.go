@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// A recurrence rule is one of
+//
+//	daily [at 9:00]
+//	weekly mon,thu [at 5pm]
+//	monthly 15 [at 17:00]
+//	cron <minute> <hour> <day of month> <month> <day of week>
+//
+// Every rule is turned into a cron schedule. Rules without a time of day
+// are due at the end of the day like other deadlines without one.
+
+var errBadRecurrence = errors.New("use daily, weekly mon,thu, monthly 15 or cron 0 9 * * 1, optionally followed by at 9:00")
+
+// cronSearchDays bounds the search for the next occurrence of a schedule
+const cronSearchDays = 366 * 5
+
+//Schedule is a parsed cron expression
+type Schedule struct {
+	minutes  [60]bool
+	hours    [24]bool
+	days     [32]bool
+	months   [13]bool
+	weekdays [7]bool
+	// anyDay and anyWeekday tell which day fields were left as *,
+	// when both are restricted a day matching either is accepted
+	anyDay     bool
+	anyWeekday bool
+}
+
+//parseRecurrence read a recurrence rule into a schedule
+func parseRecurrence(rule string) (Schedule, error) {
+	fields := strings.Fields(strings.ToLower(rule))
+	if len(fields) == 0 {
+		return Schedule{}, errBadRecurrence
+	}
+	if fields[0] == "cron" {
+		return parseCron(strings.Join(fields[1:], " "))
+	}
+	hour, minute := 23, 59
+	if n := len(fields); n >= 2 && fields[n-2] == "at" {
+		var ok bool
+		hour, minute, ok = parseClock(fields[n-1])
+		if !ok {
+			return Schedule{}, errBadRecurrence
+		}
+		fields = fields[:n-2]
+	}
+	clock := fmt.Sprintf("%d %d", minute, hour)
+	switch {
+	case len(fields) == 1 && fields[0] == "daily":
+		return parseCron(clock + " * * *")
+	case len(fields) == 2 && fields[0] == "weekly":
+		days := []string{}
+		for _, name := range strings.Split(fields[1], ",") {
+			weekday, ok := weekdays[name]
+			if !ok {
+				return Schedule{}, errBadRecurrence
+			}
+			days = append(days, strconv.Itoa(int(weekday)))
+		}
+		return parseCron(clock + " * * " + strings.Join(days, ","))
+	case len(fields) == 2 && fields[0] == "monthly":
+		return parseCron(clock + " " + fields[1] + " * *")
+	}
+	return Schedule{}, errBadRecurrence
+}
+
+//parseCron read a five field cron expression
+func parseCron(spec string) (Schedule, error) {
+	var schedule Schedule
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return schedule, errBadRecurrence
+	}
+	if err := parseCronField(fields[0], 0, 59, schedule.minutes[:]); err != nil {
+		return schedule, err
+	}
+	if err := parseCronField(fields[1], 0, 23, schedule.hours[:]); err != nil {
+		return schedule, err
+	}
+	if err := parseCronField(fields[2], 1, 31, schedule.days[:]); err != nil {
+		return schedule, err
+	}
+	if err := parseCronField(fields[3], 1, 12, schedule.months[:]); err != nil {
+		return schedule, err
+	}
+	// 7 is accepted for sunday as in most crons
+	dow := make([]bool, 8)
+	if err := parseCronField(fields[4], 0, 7, dow); err != nil {
+		return schedule, err
+	}
+	copy(schedule.weekdays[:], dow)
+	schedule.weekdays[0] = schedule.weekdays[0] || dow[7]
+	schedule.anyDay = fields[2] == "*"
+	schedule.anyWeekday = fields[4] == "*"
+	return schedule, nil
+}
+
+//parseCronField read a comma separated list of *, n, a-b, with optional /step
+func parseCronField(field string, min, max int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return errBadRecurrence
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return errBadRecurrence
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return errBadRecurrence
+			}
+			low, high = value, value
+			if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return errBadRecurrence
+		}
+		for value := low; value <= high; value += step {
+			set[value] = true
+		}
+	}
+	return nil
+}
+
+func (s Schedule) matchDay(day time.Time) bool {
+	if !s.months[day.Month()] {
+		return false
+	}
+	dayMatch := s.days[day.Day()]
+	weekdayMatch := s.weekdays[day.Weekday()]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekdayMatch
+	case s.anyWeekday:
+		return dayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+//Next return the first time of the schedule strictly after the given time,
+//in the location of that time, or the zero time if there is none
+func (s Schedule) Next(after time.Time) time.Time {
+	day := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, after.Location())
+	for i := 0; i < cronSearchDays; i++ {
+		if s.matchDay(day) {
+			for hour := 0; hour < 24; hour++ {
+				if !s.hours[hour] {
+					continue
+				}
+				for minute := 0; minute < 60; minute++ {
+					if !s.minutes[minute] {
+						continue
+					}
+					next := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+					if next.After(after) {
+						return next
+					}
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+//nextOccurrence build the task that follows a recurring task
+//The deadline moves to the next time of the rule after the current deadline,
+//or after now when the deadline is behind so missed periods are not replayed.
+func nextOccurrence(task TaskDB, location *time.Location, now time.Time) (TaskDB, error) {
+	schedule, err := parseRecurrence(task.Recurrence)
+	if err != nil {
+		return TaskDB{}, err
+	}
+	after := task.Deadline
+	if after.Before(now) {
+		after = now
+	}
+	deadline := schedule.Next(after.In(location))
+	if deadline.IsZero() {
+		return TaskDB{}, errBadRecurrence
+	}
+	return TaskDB{
+		ProjectID:   task.ProjectID,
+		ChatID:      task.ChatID,
+		Title:       task.Title,
+		Deadline:    deadline,
+		Assignees:   task.Assignees,
+		Watchers:    task.Watchers,
+		Priority:    task.Priority,
+		Labels:      task.Labels,
+		Checklist:   uncheckedChecklist(task.Checklist),
+		Description: task.Description,
+		Recurrence:  task.Recurrence,
+		CreatorID:   task.CreatorID,
+		Creator:     task.Creator,
+	}, nil
+}
+
+//spawnNextOccurrence create the next instance of a recurring task once
+func spawnNextOccurrence(storage *TaskStorage, task TaskDB, location *time.Location, now time.Time, actor Actor) (TaskDB, error) {
+	next, err := nextOccurrence(task, location, now)
+	if err != nil {
+		return next, err
+	}
+	next.moveTo(workflowOf(storage, next.ProjectID).Initial(), "", now)
+	if err := storage.CreateTask(&next, actor); err != nil {
+		return next, err
+	}
+	task.NextTaskID = next.ID
+	return next, storage.UpdateTask(task, actor)
+}
+
+//recur create the next instance of a recurring task that was just closed
+func (b Bot) recur(task TaskDB, chat *tb.Chat) {
+	if !isClosed(b.storage, task) || task.Recurrence == "" || task.NextTaskID != 0 {
+		return
+	}
+	locale := b.locale(chat.ID)
+	next, err := spawnNextOccurrence(b.storage, task, locale.Location, locale.now(), botActor(chat.ID))
+	if err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot create next occurrence of task: %s", err.Error()))
+		return
+	}
+	b.sendAbout(next, chat, fmt.Sprintf("🔁 Next *%s* is due %s", escapeMarkdown(next.Title), locale.Format(next.Deadline)), tb.ModeMarkdown)
+}
+
+func (b Bot) handleRepeat(m *tb.Message) {
+	if !m.IsReply() {
+		b.bot.Reply(m, "You should reply to a task to repeat it, eg: /repeat weekly mon at 9:00")
+		return
+	}
+	task, err := b.repliedTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot repeat task: %s", err.Error()))
+		return
+	}
+	rule := strings.TrimSpace(m.Payload)
+	locale := b.locale(m.Chat.ID)
+	if rule == "none" {
+		task.Recurrence = ""
+	} else {
+		schedule, err := parseRecurrence(rule)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot repeat task: %s", err.Error()))
+			return
+		}
+		task.Recurrence = rule
+		task.NextTaskID = 0
+		if task.Deadline.IsZero() {
+			task.Deadline = schedule.Next(locale.now())
+		}
+	}
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot repeat task: %s", err.Error()))
+		return
+	}
+	if task.Recurrence == "" {
+		b.bot.Reply(m, fmt.Sprintf("Task *%s* no longer repeats", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Task *%s* repeats %s, next due %s", escapeMarkdown(task.Title), rule, locale.Format(task.Deadline)), tb.ModeMarkdown)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	defaultReminderInterval = time.Minute
+	defaultSnooze           = time.Hour
+
+	reminderBefore  = "before"
+	reminderOverdue = "overdue"
+	reminderSnoozed = "snoozed"
+)
+
+// defaultReminderOffsets are used when the config does not set any
+var defaultReminderOffsets = []time.Duration{24 * time.Hour, time.Hour}
+
+// Inline buttons sent with every reminder, the data is the reminder id
+var (
+	reminderSnoozeBtn = tb.InlineButton{Unique: "reminder_snooze"}
+	reminderDoneBtn   = tb.InlineButton{Unique: "reminder_done"}
+)
+
+//Reminder db object
+//A reminder is one planned notification about the deadline of a task.
+type Reminder struct {
+	ID     int `storm:"id,increment"`
+	TaskID int `storm:"index"`
+	Kind   string
+	// Deadline is the task deadline the reminder was planned for,
+	// when the task deadline changes the reminder is planned again
+	Deadline time.Time
+	DueAt    time.Time
+	SentAt   time.Time
+}
+
+//Sender is the part of the telegram bot the scheduler needs
+type Sender interface {
+	Send(to tb.Recipient, what interface{}, options ...interface{}) (*tb.Message, error)
+}
+
+//Scheduler send deadline reminders and overdue alerts
+type Scheduler struct {
+	storage  *TaskStorage
+	sender   Sender
+	offsets  []time.Duration
+	interval time.Duration
+	// Now is the clock of the scheduler, replaceable in tests
+	Now    func() time.Time
+	locale func(chatID int64) DateLocale
+}
+
+//NewScheduler return new scheduler object
+func NewScheduler(storage *TaskStorage, sender Sender, offsets []time.Duration, interval time.Duration,
+	locale func(chatID int64) DateLocale) *Scheduler {
+	if len(offsets) == 0 {
+		offsets = defaultReminderOffsets
+	}
+	if interval <= 0 {
+		interval = defaultReminderInterval
+	}
+	return &Scheduler{
+		storage:  storage,
+		sender:   sender,
+		offsets:  offsets,
+		interval: interval,
+		Now:      time.Now,
+		locale:   locale,
+	}
+}
+
+//Run tick the scheduler until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	s.Tick()
+	for {
+		select {
+		case <-ticker.C:
+			s.Tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//Tick plan reminders for changed deadlines and send the ones that are due
+func (s *Scheduler) Tick() {
+	s.rollOver()
+	if err := s.plan(); err != nil {
+		log.Printf("Cannot plan reminders: %s", err.Error())
+		return
+	}
+	reminders, err := s.storage.GetReminders()
+	if err != nil {
+		return
+	}
+	now := s.Now()
+	for _, reminder := range reminders {
+		if !reminder.SentAt.IsZero() || reminder.DueAt.After(now) {
+			continue
+		}
+		task, err := s.storage.GetTask(reminder.TaskID)
+		if err != nil {
+			s.storage.DeleteReminder(reminder)
+			continue
+		}
+		if !s.send(reminder, task) {
+			// tried again on the next tick
+			continue
+		}
+		reminder.SentAt = now
+		s.storage.StoreReminder(reminder)
+	}
+}
+
+//rollOver create the next instance of recurring tasks whose period ended
+func (s *Scheduler) rollOver() {
+	now := s.Now()
+	tasks, err := s.storage.GetRecurringTasksDue(now)
+	if err != nil {
+		return
+	}
+	for _, task := range tasks {
+		chats := s.taskChats(task)
+		location := time.Local
+		if len(chats) > 0 {
+			location = s.locale(chats[0]).Location
+		}
+		next, err := spawnNextOccurrence(s.storage, task, location, now, botActor(task.ChatID))
+		if err != nil {
+			log.Printf("Cannot create next occurrence of task %d: %s", task.ID, err.Error())
+			continue
+		}
+		for _, chatID := range chats {
+			message := fmt.Sprintf("🔁 *%s* is due again %s", escapeMarkdown(next.Title), s.locale(chatID).Format(next.Deadline))
+			sent, err := s.sender.Send(&tb.Chat{ID: chatID}, message, tb.ModeMarkdown)
+			s.remember(sent, err, next)
+		}
+	}
+}
+
+//plan keep the reminders of every task in line with its deadline
+func (s *Scheduler) plan() error {
+	tasks, err := s.storage.GetTasksWithDeadline()
+	if err != nil {
+		return err
+	}
+	reminders, err := s.storage.GetReminders()
+	if err != nil {
+		return err
+	}
+	byTask := map[int][]Reminder{}
+	for _, reminder := range reminders {
+		byTask[reminder.TaskID] = append(byTask[reminder.TaskID], reminder)
+	}
+	now := s.Now()
+	for _, task := range tasks {
+		planned := byTask[task.ID]
+		delete(byTask, task.ID)
+		if isClosed(s.storage, task) {
+			s.deleteReminders(planned)
+			continue
+		}
+		upToDate := false
+		for _, reminder := range planned {
+			if reminder.Deadline.Equal(task.Deadline) {
+				upToDate = true
+			}
+		}
+		if upToDate {
+			continue
+		}
+		s.deleteReminders(planned)
+		for _, reminder := range planReminders(task, s.offsets, now) {
+			if err := s.storage.StoreReminder(reminder); err != nil {
+				return err
+			}
+		}
+	}
+	// what is left belongs to deleted tasks or removed deadlines
+	for _, planned := range byTask {
+		s.deleteReminders(planned)
+	}
+	return nil
+}
+
+func (s *Scheduler) deleteReminders(reminders []Reminder) {
+	for _, reminder := range reminders {
+		s.storage.DeleteReminder(reminder)
+	}
+}
+
+//planReminders return the reminders of a task deadline that are still ahead
+//A deadline already past when planned gets no alert, so old tasks stay quiet.
+func planReminders(task TaskDB, offsets []time.Duration, now time.Time) []Reminder {
+	reminders := []Reminder{}
+	if !task.Deadline.After(now) {
+		return reminders
+	}
+	for _, offset := range offsets {
+		dueAt := task.Deadline.Add(-offset)
+		if dueAt.After(now) {
+			reminders = append(reminders, Reminder{
+				TaskID:   task.ID,
+				Kind:     reminderBefore,
+				Deadline: task.Deadline,
+				DueAt:    dueAt,
+			})
+		}
+	}
+	reminders = append(reminders, Reminder{
+		TaskID:   task.ID,
+		Kind:     reminderOverdue,
+		Deadline: task.Deadline,
+		DueAt:    task.Deadline,
+	})
+	return reminders
+}
+
+//taskChats return the chats a task should be announced in
+func (s *Scheduler) taskChats(task TaskDB) []int64 {
+	if task.ChatID != 0 {
+		return []int64{task.ChatID}
+	}
+	// tasks created before chats were recorded
+	chats, _ := s.storage.GetChatsByDefaultProject(task.ProjectID)
+	return chats
+}
+
+//send send a reminder to the chats and assignees of its task, reporting
+//whether it reached at least one of them, or there was nobody to send it to
+func (s *Scheduler) send(reminder Reminder, task TaskDB) bool {
+	data := strconv.Itoa(reminder.ID)
+	options := &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{
+			InlineKeyboard: [][]tb.InlineButton{{
+				dataButton(reminderSnoozeBtn, "💤 Snooze 1h", data),
+				dataButton(reminderDoneBtn, "✅ Mark done", data),
+			}},
+		},
+	}
+	tried, delivered := false, false
+	chats := s.taskChats(task)
+	for _, chatID := range chats {
+		message := reminderMessage(reminder, task, s.locale(chatID), s.Now())
+		sent, err := s.sender.Send(&tb.Chat{ID: chatID}, message, options)
+		s.remember(sent, err, task)
+		tried, delivered = true, delivered || err == nil
+		if err != nil {
+			log.Printf("Cannot send reminder of task %d to chat %d: %s", task.ID, chatID, err.Error())
+		}
+	}
+	for _, assignee := range task.Assignees {
+		userID := assignee.ID
+		if userID == 0 {
+			user, err := s.storage.GetUserByUsername(assignee.Name)
+			if err != nil {
+				continue
+			}
+			userID = user.ID
+		}
+		locale := s.locale(int64(userID))
+		if len(chats) > 0 {
+			locale = s.locale(chats[0])
+		}
+		sent, err := s.sender.Send(&tb.User{ID: userID}, reminderMessage(reminder, task, locale, s.Now()), options)
+		s.remember(sent, err, task)
+		tried, delivered = true, delivered || err == nil
+		if err != nil {
+			// users have to start a private chat with the bot first
+			log.Printf("Cannot send reminder of task %d to %s: %s", task.ID, assignee.Name, err.Error())
+		}
+	}
+	return delivered || !tried
+}
+
+//remember record which task a sent message is about, so replies to it find the task
+func (s *Scheduler) remember(sent *tb.Message, err error, task TaskDB) {
+	if err == nil && sent != nil {
+		s.storage.StoreTaskMessage(sent.Chat.ID, sent.ID, []int{task.ID})
+	}
+}
+
+func reminderMessage(reminder Reminder, task TaskDB, locale DateLocale, now time.Time) string {
+	if reminder.Kind == reminderOverdue || !task.Deadline.After(now) {
+		return fmt.Sprintf("⚠️ *%s* is overdue, it was due %s", escapeMarkdown(task.Title), locale.Format(task.Deadline))
+	}
+	return fmt.Sprintf("⏰ *%s* is due %s, in %s", escapeMarkdown(task.Title), locale.Format(task.Deadline),
+		humanDuration(task.Deadline.Sub(now)))
+}
+
+//humanDuration render a duration in its largest unit, eg: "3 days"
+func humanDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d >= 48*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/time.Minute), "minute")
+	}
+}
+
+//Snooze plan the reminder again after the snooze delay
+func (s *Scheduler) Snooze(reminder Reminder, delay time.Duration) error {
+	return s.storage.StoreReminder(Reminder{
+		TaskID:   reminder.TaskID,
+		Kind:     reminderSnoozed,
+		Deadline: reminder.Deadline,
+		DueAt:    s.Now().Add(delay),
+	})
+}
+
+//reminderTask return the task of a reminder button and the message to act on
+//it as, from the chat of the task or from the private chat of an assignee.
+//It answers the callback when the task is gone or the presser cannot edit it.
+func (b Bot) reminderTask(c *tb.Callback, reminder Reminder) (TaskDB, *tb.Message, bool) {
+	m := callbackMessage(c)
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, reminder.TaskID)
+	if err != nil && c.Message.Chat.Type == tb.ChatPrivate {
+		var ok bool
+		if task, ok = b.dashboardTask(c, reminder.TaskID); !ok {
+			return task, m, false
+		}
+		m = &tb.Message{Sender: c.Sender, Chat: &tb.Chat{ID: b.originChat(task)}}
+	} else if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return task, m, false
+	}
+	if ok, refusal := b.allowed(m.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return task, m, false
+	}
+	return task, m, true
+}
+
+func (b Bot) handleReminderSnooze(c *tb.Callback) {
+	reminderID, _ := strconv.Atoi(c.Data)
+	reminder, err := b.storage.GetReminder(reminderID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This reminder is no longer active"})
+		return
+	}
+	if _, _, ok := b.reminderTask(c, reminder); !ok {
+		return
+	}
+	if err := b.scheduler.Snooze(reminder, defaultSnooze); err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Cannot snooze: %s", err.Error())})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{Text: "I will remind you again in 1 hour"})
+}
+
+//handleReminderDone move the task of a reminder to the closed state through
+//setStatus, from the chat of the task or from the private chat of an assignee
+func (b Bot) handleReminderDone(c *tb.Callback) {
+	reminderID, _ := strconv.Atoi(c.Data)
+	reminder, err := b.storage.GetReminder(reminderID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This reminder is no longer active"})
+		return
+	}
+	task, m, ok := b.reminderTask(c, reminder)
+	if !ok {
+		return
+	}
+	workflow := workflowOf(b.storage, task.ProjectID)
+	status := workflow.DoneState()
+	if !contains(workflow.Next(task.Status), status) {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("This task cannot move from %s to %s, use /set_status",
+			workflow.Normalize(task.Status), status), ShowAlert: true})
+		return
+	}
+	if blocked := b.blockedMove(task, status); blocked != "" {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: blocked, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setStatus(task.ID, status, m)
+	if task, err = b.storage.GetTask(task.ID); err == nil && isClosed(b.storage, task) {
+		b.bot.Edit(c.Message, fmt.Sprintf("✅ *%s* is done", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+	}
+}
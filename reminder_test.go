@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+//fakeSender remember what the scheduler sends instead of calling telegram,
+//failing every send with err when it is set
+type fakeSender struct {
+	sent []string
+	err  error
+}
+
+func (f *fakeSender) Send(to tb.Recipient, what interface{}, options ...interface{}) (*tb.Message, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.sent = append(f.sent, what.(string))
+	return nil, nil
+}
+
+func newTestStorage(t *testing.T) *TaskStorage {
+	db, err := storm.Open(t.TempDir() + "/task.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &TaskStorage{db: db}
+}
+
+//newTestScheduler return a scheduler reminding 1 hour before deadlines, with
+//a clock the test moves
+func newTestScheduler(t *testing.T) (*Scheduler, *fakeSender, *time.Time) {
+	now := testNow
+	sender := &fakeSender{}
+	scheduler := NewScheduler(newTestStorage(t), sender, []time.Duration{time.Hour}, time.Minute,
+		func(int64) DateLocale { return testLocale(true) })
+	scheduler.Now = func() time.Time { return now }
+	return scheduler, sender, &now
+}
+
+func storeTestTask(t *testing.T, storage *TaskStorage, deadline time.Time) TaskDB {
+	project, err := storage.StoreProject(Project{Title: "Ops", Creator: "an"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := storage.StoreTask(Task{Title: "Fix login", Status: "todo", Deadline: deadline}, project.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestPlanReminders(t *testing.T) {
+	offsets := []time.Duration{24 * time.Hour, time.Hour}
+	tests := []struct {
+		deadline time.Time
+		kinds    []string
+	}{
+		{testNow.Add(48 * time.Hour), []string{reminderBefore, reminderBefore, reminderOverdue}},
+		{testNow.Add(2 * time.Hour), []string{reminderBefore, reminderOverdue}},
+		{testNow.Add(30 * time.Minute), []string{reminderOverdue}},
+		{testNow.Add(-time.Hour), []string{}},
+	}
+	for _, test := range tests {
+		reminders := planReminders(TaskDB{ID: 1, Deadline: test.deadline}, offsets, testNow)
+		kinds := []string{}
+		for _, reminder := range reminders {
+			kinds = append(kinds, reminder.Kind)
+			if !reminder.Deadline.Equal(test.deadline) || !reminder.DueAt.After(testNow) {
+				t.Errorf("reminder %+v is not ahead of %s for deadline %s", reminder, testNow, test.deadline)
+			}
+		}
+		if len(kinds) != len(test.kinds) {
+			t.Errorf("deadline %s planned %v, want %v", test.deadline, kinds, test.kinds)
+			continue
+		}
+		for i := range kinds {
+			if kinds[i] != test.kinds[i] {
+				t.Errorf("deadline %s planned %v, want %v", test.deadline, kinds, test.kinds)
+				break
+			}
+		}
+	}
+}
+
+func TestSchedulerTick(t *testing.T) {
+	scheduler, sender, now := newTestScheduler(t)
+	storeTestTask(t, scheduler.storage, testNow.Add(2*time.Hour))
+
+	scheduler.Tick()
+	if len(sender.sent) != 0 {
+		t.Fatalf("sent %v before any reminder was due", sender.sent)
+	}
+	if reminders, _ := scheduler.storage.GetReminders(); len(reminders) != 2 {
+		t.Fatalf("planned %d reminders, want 2", len(reminders))
+	}
+
+	*now = testNow.Add(time.Hour)
+	scheduler.Tick()
+	scheduler.Tick()
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages an hour before the deadline, want 1", len(sender.sent))
+	}
+
+	*now = testNow.Add(3 * time.Hour)
+	scheduler.Tick()
+	if len(sender.sent) != 2 {
+		t.Fatalf("sent %d messages after the deadline, want 2", len(sender.sent))
+	}
+}
+
+func TestSchedulerRetriesFailedReminders(t *testing.T) {
+	scheduler, sender, now := newTestScheduler(t)
+	storeTestTask(t, scheduler.storage, testNow.Add(30*time.Minute))
+	sender.err = errors.New("api error: Bad Request: can't parse entities")
+	scheduler.Tick()
+
+	*now = testNow.Add(time.Hour)
+	scheduler.Tick()
+	if reminders, _ := scheduler.storage.GetReminders(); len(reminders) != 1 || !reminders[0].SentAt.IsZero() {
+		t.Fatalf("reminders %+v are marked sent although no send succeeded", reminders)
+	}
+
+	sender.err = nil
+	scheduler.Tick()
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages once sending works again, want 1", len(sender.sent))
+	}
+}
+
+func TestReminderMessageEscapesTitle(t *testing.T) {
+	task := TaskDB{Title: "fix db_backup", Deadline: testNow.Add(time.Hour)}
+	for _, kind := range []string{reminderBefore, reminderOverdue} {
+		message := reminderMessage(Reminder{Kind: kind}, task, testLocale(true), testNow)
+		if !strings.Contains(message, escapeMarkdown(task.Title)) {
+			t.Errorf("%s reminder %q does not escape the title", kind, message)
+		}
+	}
+}
+
+func TestSchedulerPlanFollowsTasks(t *testing.T) {
+	scheduler, _, _ := newTestScheduler(t)
+	task := storeTestTask(t, scheduler.storage, testNow.Add(2*time.Hour))
+	if err := scheduler.plan(); err != nil {
+		t.Fatal(err)
+	}
+
+	task.Deadline = testNow.Add(30 * time.Minute)
+	if err := scheduler.storage.UpdateTask(task, Actor{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.plan(); err != nil {
+		t.Fatal(err)
+	}
+	reminders, _ := scheduler.storage.GetReminders()
+	if len(reminders) != 1 || reminders[0].Kind != reminderOverdue || !reminders[0].Deadline.Equal(task.Deadline) {
+		t.Fatalf("reminders %+v are not planned again for the new deadline", reminders)
+	}
+
+	task.moveTo("done", "an", testNow)
+	if err := scheduler.storage.UpdateTask(task, Actor{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.plan(); err != nil {
+		t.Fatal(err)
+	}
+	if reminders, _ := scheduler.storage.GetReminders(); len(reminders) != 0 {
+		t.Fatalf("closed task still has reminders %+v", reminders)
+	}
+}
+
+func TestSchedulerSnooze(t *testing.T) {
+	scheduler, sender, now := newTestScheduler(t)
+	task := storeTestTask(t, scheduler.storage, testNow.Add(-time.Hour))
+	reminder := Reminder{TaskID: task.ID, Kind: reminderOverdue, Deadline: task.Deadline, DueAt: task.Deadline}
+	if err := scheduler.Snooze(reminder, defaultSnooze); err != nil {
+		t.Fatal(err)
+	}
+	reminders, _ := scheduler.storage.GetReminders()
+	if len(reminders) != 1 || reminders[0].Kind != reminderSnoozed || !reminders[0].DueAt.Equal(testNow.Add(defaultSnooze)) {
+		t.Fatalf("snoozed reminders are %+v, want one due at %s", reminders, testNow.Add(defaultSnooze))
+	}
+
+	*now = testNow.Add(defaultSnooze - time.Minute)
+	scheduler.Tick()
+	if len(sender.sent) != 0 {
+		t.Fatalf("sent %v before the snooze ended", sender.sent)
+	}
+	*now = testNow.Add(defaultSnooze)
+	scheduler.Tick()
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d messages once the snooze ended, want 1", len(sender.sent))
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// defaultSessionTimeout is used when the config does not set one
+const defaultSessionTimeout = 10 * time.Minute
+
+//SessionState is the step a user is at in a multi-message dialog
+type SessionState string
+
+const (
+	//StateIdle means the user is not in the middle of any dialog
+	StateIdle SessionState = ""
+	//StateCreateTask waits for the task title or a whole task line
+	StateCreateTask SessionState = "create_task"
+	//StateTaskAssignee waits for the assignee of the task draft
+	StateTaskAssignee SessionState = "task_assignee"
+	//StateTaskDeadline waits for the deadline of the task draft
+	StateTaskDeadline SessionState = "task_deadline"
+	//StateTaskDescription waits for the description of the task draft
+	StateTaskDescription SessionState = "task_description"
+	//StateTaskConfirm waits for the task draft to be saved or edited
+	StateTaskConfirm SessionState = "task_confirm"
+	//StateCreateProject waits for the project name
+	StateCreateProject SessionState = "create_project"
+	//StateAssignTask waits for a task id and a mention
+	StateAssignTask SessionState = "assign_task"
+	//StateSetDeadline waits for the deadline of the session task
+	StateSetDeadline SessionState = "set_deadline"
+	//StateEditTitle waits for the new title of the session task
+	StateEditTitle SessionState = "edit_title"
+	//StateEditDescription waits for the new description of the session task
+	StateEditDescription SessionState = "edit_description"
+	//StateSetLabels waits for the labels to add to or remove from the session task
+	StateSetLabels SessionState = "set_labels"
+	//StateAddSubtask waits for a subtask of the session task in the single-line syntax
+	StateAddSubtask SessionState = "add_subtask"
+	//StateAddChecklistItem waits for items to add to the checklist of the session task
+	StateAddChecklistItem SessionState = "add_checklist_item"
+)
+
+//Session db object
+//A session holds the dialog state of one user in one chat.
+type Session struct {
+	Key       string `storm:"id"`
+	SenderID  int
+	ChatID    int64 `storm:"index"`
+	State     SessionState
+	TaskID    int
+	Data      map[string]string
+	UpdatedAt time.Time
+}
+
+//SessionManager keeps dialog sessions keyed by sender and chat
+type SessionManager struct {
+	mu      sync.Mutex
+	storage *TaskStorage
+	timeout time.Duration
+	now     func() time.Time
+}
+
+func sessionKey(senderID int, chatID int64) string {
+	return fmt.Sprintf("%d_%d", senderID, chatID)
+}
+
+//NewSessionManager return new session manager object
+func NewSessionManager(storage *TaskStorage, timeout time.Duration) *SessionManager {
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	return &SessionManager{
+		storage: storage,
+		timeout: timeout,
+		now:     time.Now,
+	}
+}
+
+//Get return the active session of the message sender in the message chat
+//An expired session is removed and reported as idle.
+func (s *SessionManager) Get(m *tb.Message) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(m.Sender.ID, m.Chat.ID)
+}
+
+func (s *SessionManager) get(senderID int, chatID int64) Session {
+	key := sessionKey(senderID, chatID)
+	idle := Session{
+		Key:      key,
+		SenderID: senderID,
+		ChatID:   chatID,
+	}
+	session, err := s.storage.GetSession(key)
+	if err != nil {
+		return idle
+	}
+	if s.now().Sub(session.UpdatedAt) > s.timeout {
+		s.storage.DeleteSession(key)
+		return idle
+	}
+	return session
+}
+
+//Start put the message sender into a new dialog state
+//Any unfinished dialog of the same sender in the same chat is replaced.
+func (s *SessionManager) Start(m *tb.Message, state SessionState, taskID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := Session{
+		Key:       sessionKey(m.Sender.ID, m.Chat.ID),
+		SenderID:  m.Sender.ID,
+		ChatID:    m.Chat.ID,
+		State:     state,
+		TaskID:    taskID,
+		Data:      map[string]string{},
+		UpdatedAt: s.now(),
+	}
+	return s.storage.StoreSession(session)
+}
+
+//Update apply fn to the active session of the sender and save it
+func (s *SessionManager) Update(m *tb.Message, fn func(*Session)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.get(m.Sender.ID, m.Chat.ID)
+	if session.Data == nil {
+		session.Data = map[string]string{}
+	}
+	fn(&session)
+	session.UpdatedAt = s.now()
+	return s.storage.StoreSession(session)
+}
+
+//Finish end the dialog of the message sender
+func (s *SessionManager) Finish(m *tb.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.storage.DeleteSession(sessionKey(m.Sender.ID, m.Chat.ID))
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+//PurgeExpired remove every session older than the timeout
+func (s *SessionManager) PurgeExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.storage.DeleteSessionsBefore(s.now().Add(-s.timeout))
+	if err != nil {
+		log.Printf("Cannot purge expired sessions: %s", err.Error())
+		return
+	}
+	if n > 0 {
+		log.Printf("Purged %d expired sessions", n)
+	}
+}
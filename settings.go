@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	dateOrderDayFirst   = "dmy"
+	dateOrderMonthFirst = "mdy"
+)
+
+//localeOf build the date locale from chat settings,
+//using the bot defaults for anything the chat did not set
+func (b Bot) localeOf(settings ChatSettings) DateLocale {
+	timeZone := settings.TimeZone
+	if timeZone == "" {
+		timeZone = b.defaults.TimeZone
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		location = time.Local
+	}
+	dateOrder := settings.DateOrder
+	if dateOrder == "" {
+		dateOrder = b.defaults.DateOrder
+	}
+	return DateLocale{
+		Location: location,
+		DayFirst: dateOrder != dateOrderMonthFirst,
+	}
+}
+
+//locale return the date locale of a chat
+func (b Bot) locale(chatID int64) DateLocale {
+	settings, _ := b.storage.GetChatSettings(chatID)
+	return b.localeOf(settings)
+}
+
+func (b Bot) handleTimeZone(m *tb.Message) {
+	settings, err := b.storage.GetChatSettings(m.Chat.ID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get chat settings: %s", err.Error()))
+		return
+	}
+	timeZone := strings.TrimSpace(m.Payload)
+	if timeZone == "" {
+		locale := b.localeOf(settings)
+		b.bot.Reply(m, fmt.Sprintf("Time zone of this chat is %s, now is %s. Change it with eg: /timezone Asia/Ho_Chi_Minh",
+			locale.Location, locale.now().Format("Mon 15:04")))
+		return
+	}
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Unknown time zone %s, use a name like Europe/Berlin", timeZone))
+		return
+	}
+	settings.TimeZone = timeZone
+	if err := b.storage.StoreChatSettings(settings); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set time zone: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Time zone of this chat now is %s", timeZone))
+}
+
+func (b Bot) handleDateOrder(m *tb.Message) {
+	settings, err := b.storage.GetChatSettings(m.Chat.ID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get chat settings: %s", err.Error()))
+		return
+	}
+	order := strings.ToLower(strings.TrimSpace(m.Payload))
+	if order != dateOrderDayFirst && order != dateOrderMonthFirst {
+		b.bot.Reply(m, "Send /date_order dmy to read 12/04 as 12 April, or /date_order mdy for December 4")
+		return
+	}
+	settings.DateOrder = order
+	if err := b.storage.StoreChatSettings(settings); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set date order: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Dates in this chat are now read as %s", order))
+}
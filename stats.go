@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// burndownDays is how many days the burndown sparkline covers
+const burndownDays = 14
+
+// statsWindows are the periods, in days, tasks created and completed are counted over
+var statsWindows = []int{7, 30}
+
+// sparkBars draw the sparkline, from the fewest to the most open tasks
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+//createdAt return when a task was created, zero for tasks older than their history
+func (t TaskDB) createdAt() time.Time {
+	if len(t.History) == 0 {
+		return time.Time{}
+	}
+	return t.History[0].At
+}
+
+//startedAt return when a task first left the initial state for a state that
+//is not closed, eg: doing, zero when it never did
+func (t TaskDB) startedAt(workflow Workflow) time.Time {
+	for _, change := range t.History {
+		status := workflow.Normalize(change.Status)
+		if status != workflow.Initial() && !workflow.IsClosed(status) {
+			return change.At
+		}
+	}
+	return time.Time{}
+}
+
+//closedAt return when a closed task last moved to a closed state,
+//zero for open tasks
+func (t TaskDB) closedAt(workflow Workflow) time.Time {
+	if !workflow.IsClosed(t.Status) {
+		return time.Time{}
+	}
+	closed := time.Time{}
+	for _, change := range t.History {
+		switch {
+		case !workflow.IsClosed(change.Status):
+			closed = time.Time{}
+		case closed.IsZero():
+			closed = change.At
+		}
+	}
+	return closed
+}
+
+//openAt report whether a task existed and was open at a time
+//Tasks without history are taken as always in their current status.
+func (t TaskDB) openAt(workflow Workflow, at time.Time) bool {
+	if len(t.History) == 0 {
+		return !workflow.IsClosed(t.Status)
+	}
+	existed, open := false, false
+	for _, change := range t.History {
+		if change.At.After(at) {
+			break
+		}
+		existed, open = true, !workflow.IsClosed(change.Status)
+	}
+	return existed && open
+}
+
+//sparkline draw values as bars, the highest value the full bar
+func sparkline(values []int) string {
+	highest := 0
+	for _, value := range values {
+		if value > highest {
+			highest = value
+		}
+	}
+	line := ""
+	for _, value := range values {
+		bar := 0
+		if highest > 0 {
+			bar = value * (len(sparkBars) - 1) / highest
+		}
+		line += string(sparkBars[bar])
+	}
+	return line
+}
+
+//projectStatsText render the statistics of a project: tasks per status and
+//per assignee, overdue tasks, tasks created and completed lately, the
+//average cycle time and a burndown of the open tasks
+func (b Bot) projectStatsText(project ProjectDB, locale DateLocale) (string, error) {
+	tasks, err := b.storage.FindTasks(q.Eq("ProjectID", project.ID))
+	if err != nil {
+		return "", err
+	}
+	workflow := workflowOf(b.storage, project.ID)
+	now := locale.now()
+	perStatus := map[string]int{}
+	load := newWorkload()
+	overdue := 0
+	created := make([]int, len(statsWindows))
+	completed := make([]int, len(statsWindows))
+	var cycleTime time.Duration
+	cycles := 0
+	for _, task := range tasks {
+		perStatus[workflow.Normalize(task.Status)]++
+		for i, days := range statsWindows {
+			since := now.AddDate(0, 0, -days)
+			if task.createdAt().After(since) {
+				created[i]++
+			}
+			if task.closedAt(workflow).After(since) {
+				completed[i]++
+			}
+		}
+		if workflow.IsClosed(task.Status) {
+			started, closed := task.startedAt(workflow), task.closedAt(workflow)
+			if !started.IsZero() && closed.After(started) {
+				cycleTime += closed.Sub(started)
+				cycles++
+			}
+			continue
+		}
+		load.add(task, now)
+		if !task.Deadline.IsZero() && task.Deadline.Before(now) {
+			overdue++
+		}
+	}
+
+	message := fmt.Sprintf("📊 *%s* statistics, %d tasks\n", project.Title, len(tasks))
+	message += "\n*By status*\n"
+	states := append([]string{}, workflow.States...)
+	for status := range perStatus {
+		if !workflow.Has(status) {
+			states = append(states, status)
+		}
+	}
+	for _, state := range states {
+		message += fmt.Sprintf("%s: %d\n", escapeMarkdown(state), perStatus[state])
+	}
+	message += load.text("Open tasks by assignee")
+	message += fmt.Sprintf("\n⚠️ Overdue: %d\n", overdue)
+	message += "\n*Created / completed*\n"
+	for i, days := range statsWindows {
+		message += fmt.Sprintf("last %d days: %d / %d\n", days, created[i], completed[i])
+	}
+	if cycles > 0 {
+		message += fmt.Sprintf("\n⏱ Average cycle time: %s over %d tasks\n",
+			humanDuration(cycleTime/time.Duration(cycles)), cycles)
+	} else {
+		message += "\n⏱ Average cycle time: no task went from started to closed yet\n"
+	}
+	burndown := []int{}
+	for day := burndownDays - 1; day >= 0; day-- {
+		at := now
+		if day > 0 {
+			at = endOfDay(now.AddDate(0, 0, -day))
+		}
+		count := 0
+		for _, task := range tasks {
+			if task.openAt(workflow, at) {
+				count++
+			}
+		}
+		burndown = append(burndown, count)
+	}
+	message += fmt.Sprintf("\n📉 Open tasks over %d days: %s %d → %d\n", burndownDays, sparkline(burndown),
+		burndown[0], burndown[len(burndown)-1])
+	return strings.TrimSuffix(message, "\n"), nil
+}
+
+//handleProjectStats show the statistics of the current project, or of the
+//project given by its id or key, eg: /project_stats OPS
+func (b Bot) handleProjectStats(m *tb.Message) {
+	project, err := b.projectOf(m, strings.TrimSpace(m.Payload))
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get project statistics: %s", err.Error()))
+		return
+	}
+	message, err := b.projectStatsText(project, b.locale(m.Chat.ID))
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get project statistics: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, message, tb.ModeMarkdown)
+}
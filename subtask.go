@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// checklistBtn checks or unchecks an item of a checklist, its data is "<task id>:<item index>"
+var checklistBtn = tb.InlineButton{Unique: "checklist"}
+
+//ChecklistItem is a step of a task too small to be a task
+type ChecklistItem struct {
+	Text string
+	Done bool
+}
+
+//checkMark is the mark of something done or not
+func checkMark(done bool) string {
+	if done {
+		return "✅"
+	}
+	return "⬜"
+}
+
+//subtasks get the child tasks of a task, oldest first
+func (b Bot) subtasks(task TaskDB) []TaskDB {
+	children, _ := b.storage.FindTasks(q.Eq("ParentID", task.ID))
+	return children
+}
+
+//taskProgress count the checklist items and subtasks of a task, and how many are done
+func (b Bot) taskProgress(task TaskDB) (int, int) {
+	done, total := 0, len(task.Checklist)
+	for _, item := range task.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	for _, child := range b.subtasks(task) {
+		total++
+		if isClosed(b.storage, child) {
+			done++
+		}
+	}
+	return done, total
+}
+
+//progressText tell how much of a task is done, eg: "3/5 done", empty for a task without steps
+func (b Bot) progressText(task TaskDB) string {
+	done, total := b.taskProgress(task)
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d done", done, total)
+}
+
+//uncheckedChecklist copy a checklist with no item done, for a task starting over
+func uncheckedChecklist(items []ChecklistItem) []ChecklistItem {
+	unchecked := []ChecklistItem{}
+	for _, item := range items {
+		unchecked = append(unchecked, ChecklistItem{Text: item.Text})
+	}
+	return unchecked
+}
+
+//checklistText render the checklist of a task, numbered from 1
+func checklistText(items []ChecklistItem) string {
+	message := ""
+	for i, item := range items {
+		message += fmt.Sprintf("%s %d. %s\n", checkMark(item.Done), i+1, escapeMarkdown(item.Text))
+	}
+	return message
+}
+
+//checklistSummary write a checklist on one line for the activity log, eg: "✅ a, ⬜ b"
+func checklistSummary(items []ChecklistItem) string {
+	list := []string{}
+	for _, item := range items {
+		list = append(list, checkMark(item.Done)+" "+item.Text)
+	}
+	return strings.Join(list, ", ")
+}
+
+//handleSubtask create a task under the replied task, or the task given by its
+//id or key, in the single-line syntax, eg: /subtask OPS-3 Write tests - @an
+func (b Bot) handleSubtask(m *tb.Message) {
+	parent, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot create subtask: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, parent.ProjectID, permEditTasks) {
+		return
+	}
+	used := 0
+	if !m.IsReply() {
+		used = 1
+	}
+	line := afterWords(m.Payload, used)
+	if line == "" {
+		b.askSubtask(parent, m)
+		return
+	}
+	b.saveSubtask(parent.ID, line, m)
+}
+
+//askSubtask wait for a subtask of a task in the single-line syntax
+func (b Bot) askSubtask(parent TaskDB, m *tb.Message) {
+	if err := b.sessions.Start(m, StateAddSubtask, parent.ID); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create subtask: %s", err.Error()))
+		return
+	}
+	b.bot.Send(m.Chat, fmt.Sprintf("Send the subtask of *%s* in one line, eg: Write tests - @an - friday", parent.Title),
+		tb.ModeMarkdown)
+}
+
+//saveSubtask create a task written in the single-line syntax in the project of its parent
+func (b Bot) saveSubtask(parentID int, line string, m *tb.Message) {
+	parent, err := b.storage.GetChatTask(m.Chat.ID, parentID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot create subtask: %s", err.Error()))
+		return
+	}
+	task, err := parseTaskLine(line, b.locale(m.Chat.ID))
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot create subtask: %s", err.Error()))
+		return
+	}
+	task.ParentID = parent.ID
+	task.Status = workflowOf(b.storage, parent.ProjectID).Initial()
+	task.CreatorID = m.Sender.ID
+	task.Creator = displayName(m.Sender)
+	task.Assignees = b.knownPeople(task.Assignees)
+	created, err := b.storage.StoreTask(task, parent.ProjectID, m.Chat.ID)
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create subtask: %s", err.Error()))
+		return
+	}
+	b.sendAbout(created, m.Chat, fmt.Sprintf("Created *%s* *%s* under *%s* *%s*, %s", b.taskKey(created),
+		created.Title, b.taskKey(parent), parent.Title, b.progressText(parent)), tb.ModeMarkdown)
+}
+
+//handleChecklist add an item to the checklist of a task, or show the checklist
+//with buttons checking its items, eg: /checklist OPS-3 Update the docs
+func (b Bot) handleChecklist(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change checklist: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permEditTasks) {
+		return
+	}
+	used := 0
+	if !m.IsReply() {
+		used = 1
+	}
+	text := afterWords(m.Payload, used)
+	if text == "" {
+		b.showChecklist(task, m)
+		return
+	}
+	b.addChecklistItems(task.ID, text, m)
+}
+
+//checklistKeyboard is a button checking or unchecking each item of a checklist
+func checklistKeyboard(task TaskDB) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	for i, item := range task.Checklist {
+		keys = append(keys, []tb.InlineButton{dataButton(checklistBtn,
+			fmt.Sprintf("%s %s", checkMark(item.Done), shorten(item.Text, maxButtonTitle)), fmt.Sprintf("%d:%d", task.ID, i))})
+	}
+	return keys
+}
+
+//checklistMessage render the checklist of a task with its progress
+func (b Bot) checklistMessage(task TaskDB) string {
+	message := fmt.Sprintf("*%s* *%s* checklist", b.taskKey(task), task.Title)
+	if progress := b.progressText(task); progress != "" {
+		message += ", " + progress
+	}
+	return message + ":\n" + orNone(checklistText(task.Checklist))
+}
+
+//showChecklist send the checklist of a task with buttons checking its items,
+//and wait for items to add
+func (b Bot) showChecklist(task TaskDB, m *tb.Message) {
+	if err := b.sessions.Start(m, StateAddChecklistItem, task.ID); err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot change checklist: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, m.Chat, b.checklistMessage(task)+"\nSend items to add, one per line", &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: checklistKeyboard(task)},
+	})
+}
+
+//addChecklistItems add each line of a text to the checklist of a task
+func (b Bot) addChecklistItems(taskID int, text string, m *tb.Message) {
+	task, err := b.storage.GetChatTask(m.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change checklist: %s", err.Error()))
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			task.Checklist = append(task.Checklist, ChecklistItem{Text: line})
+		}
+	}
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change checklist: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, m.Chat, b.checklistMessage(task), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: checklistKeyboard(task)},
+	})
+}
+
+//handleChecklistButton check or uncheck the item picked, and close the task
+//when everything in it is done
+func (b Bot) handleChecklistButton(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	index, _ := strconv.Atoi(parts[1])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil || index < 0 || index >= len(task.Checklist) {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This item does not exist anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	m := callbackMessage(c)
+	task.Checklist[index].Done = !task.Checklist[index].Done
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Cannot change checklist: %s", err.Error())})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.bot.Edit(c.Message, b.checklistMessage(task), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: checklistKeyboard(task)},
+	})
+	if task.Checklist[index].Done {
+		b.completeTask(task, m)
+	}
+}
+
+//completeParent close the parent of a task that was just closed once all its
+//steps are done
+func (b Bot) completeParent(task TaskDB, m *tb.Message) {
+	if task.ParentID == 0 || !isClosed(b.storage, task) {
+		return
+	}
+	parent, err := b.storage.GetTask(task.ParentID)
+	if err != nil {
+		return
+	}
+	b.completeTask(parent, m)
+}
+
+//completeTask move a task to the closed state when all its checklist items
+//and subtasks are done, or ask to when its workflow does not allow it yet
+func (b Bot) completeTask(task TaskDB, m *tb.Message) {
+	done, total := b.taskProgress(task)
+	if total == 0 || done < total || isClosed(b.storage, task) {
+		return
+	}
+	chat := b.chatOf(task, m)
+	workflow := workflowOf(b.storage, task.ProjectID)
+	status := workflow.DoneState()
+	if !contains(workflow.Next(task.Status), status) {
+		b.sendAbout(task, chat, fmt.Sprintf("Everything in *%s* *%s* is done, move it to:", b.taskKey(task), task.Title),
+			&tb.SendOptions{
+				ParseMode:   tb.ModeMarkdown,
+				ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: statusKeyboard(task, workflow)},
+			})
+		return
+	}
+	task.moveTo(status, displayName(m.Sender), time.Now())
+	if err := b.updateTask(task, m); err != nil {
+		b.bot.Send(chat, fmt.Sprintf("Cannot set status task: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, chat, fmt.Sprintf("Everything in *%s* *%s* is done, moved it to *%s*", b.taskKey(task),
+		task.Title, status), tb.ModeMarkdown)
+	b.recur(task, chat)
+	b.completeParent(task, m)
+	b.unblockDependents(task, m)
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// tasksPerPage is how many tasks one list message shows
+const tasksPerPage = 10
+
+// maxButtonTitle keeps task buttons readable on phones
+const maxButtonTitle = 24
+
+// taskPageBtn moves a task list to another page or filter, its data is
+// the encoded page so a list keeps working after the bot restarts
+var taskPageBtn = tb.InlineButton{Unique: "task_page"}
+
+// taskFilterBtn shows the choices of one filter of a task list,
+// its data is "<filter>|<encoded page>"
+var taskFilterBtn = tb.InlineButton{Unique: "task_filter"}
+
+// filters picked from a list of choices
+const (
+	filterAssignee = "a"
+	filterProject  = "p"
+	filterDue      = "d"
+	filterPriority = "r"
+	filterLabel    = "l"
+)
+
+// orders of a task list, by id when none is set
+const (
+	sortPriority = "priority"
+	sortDue      = "due"
+)
+
+var sortOrders = []string{sortPriority, sortDue}
+
+// deadline windows of a task filter
+const (
+	dueOverdue = "overdue"
+	dueToday   = "today"
+	dueWeek    = "week"
+	dueNone    = "none"
+)
+
+var dueWindows = []string{dueOverdue, dueToday, dueWeek, dueNone}
+
+// maxPageData is the longest page kept in callback data: telegram allows 64
+// bytes, telebot adds "\f<unique>|" and filter buttons the filter kind
+const maxPageData = 48
+
+// savedPagePrefix starts the data of pages whose filter is saved, eg: "~12|3"
+const savedPagePrefix = "~"
+
+var errBadTaskPage = errors.New("bad task page")
+
+var errBadTaskFilter = errors.New("filter by state, @username, project:<id>, due:overdue|today|week|none, !priority or #label and sort by sort:priority|due, eg: /list_tasks doing @halink0803 due:week !high sort:due")
+
+//TaskFilter select the tasks of a list
+//Empty fields do not filter, set fields must all match.
+type TaskFilter struct {
+	// Status is a workflow state
+	Status string
+	// Assignee is a username without "@"
+	Assignee  string
+	ProjectID int
+	// Due is one of the deadline windows
+	Due string
+	// Priority is one of priorities, Label a label without "#"
+	Priority string
+	Label    string
+	// Sort is one of sortOrders
+	Sort string
+}
+
+//parseTaskFilter read a filter written as words, eg: "doing @halink0803 project:2 due:week"
+func parseTaskFilter(text string) (TaskFilter, error) {
+	filter := TaskFilter{}
+	for _, word := range strings.Fields(text) {
+		switch {
+		case mentionPattern.MatchString(word):
+			filter.Assignee = strings.TrimPrefix(word, "@")
+		case strings.HasPrefix(word, "project:"):
+			projectID, err := strconv.Atoi(strings.TrimPrefix(word, "project:"))
+			if err != nil {
+				return filter, errBadTaskFilter
+			}
+			filter.ProjectID = projectID
+		case strings.HasPrefix(word, "due:"):
+			filter.Due = strings.ToLower(strings.TrimPrefix(word, "due:"))
+			if !contains(dueWindows, filter.Due) {
+				return filter, errBadTaskFilter
+			}
+		case strings.HasPrefix(word, "!"):
+			priority, err := parsePriority(word)
+			if err != nil || priority == "" {
+				return filter, errBadTaskFilter
+			}
+			filter.Priority = priority
+		case labelPattern.MatchString(word):
+			filter.Label = normalizeLabel(word)
+		case strings.HasPrefix(word, "sort:"):
+			filter.Sort = strings.ToLower(strings.TrimPrefix(word, "sort:"))
+			if !contains(sortOrders, filter.Sort) {
+				return filter, errBadTaskFilter
+			}
+		case strings.HasPrefix(word, "status:"):
+			filter.Status = strings.ToLower(strings.TrimPrefix(word, "status:"))
+		case !strings.Contains(word, ":"):
+			filter.Status = strings.ToLower(word)
+		default:
+			return filter, errBadTaskFilter
+		}
+	}
+	return filter, nil
+}
+
+//matchers build the storm query of the filter
+//Status is matched with the stored statuses of the workflow state,
+//deadline windows are computed from now in the location of the chat.
+func (f TaskFilter) matchers(workflow Workflow, now time.Time) []q.Matcher {
+	matchers := []q.Matcher{}
+	if f.Status != "" {
+		matchers = append(matchers, q.In("Status", workflow.Statuses(f.Status)))
+	}
+	if f.Assignee != "" {
+		matchers = append(matchers, hasPerson("Assignees", Person{Name: f.Assignee}))
+	}
+	if f.ProjectID != 0 {
+		matchers = append(matchers, q.Eq("ProjectID", f.ProjectID))
+	}
+	if f.Priority != "" {
+		matchers = append(matchers, q.Eq("Priority", f.Priority))
+	}
+	if f.Label != "" {
+		matchers = append(matchers, hasLabel(f.Label))
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch f.Due {
+	case dueOverdue:
+		matchers = append(matchers, q.Not(q.Eq("Deadline", time.Time{})), q.Lt("Deadline", now))
+	case dueToday:
+		matchers = append(matchers, q.Gte("Deadline", today), q.Lt("Deadline", today.AddDate(0, 0, 1)))
+	case dueWeek:
+		matchers = append(matchers, q.Gte("Deadline", now), q.Lt("Deadline", today.AddDate(0, 0, 8)))
+	case dueNone:
+		matchers = append(matchers, q.Eq("Deadline", time.Time{}))
+	}
+	return matchers
+}
+
+//sortTasks order tasks by priority or deadline, keeping the order of ties
+//Tasks without priority or deadline come last.
+func sortTasks(tasks []TaskDB, order string) {
+	switch order {
+	case sortPriority:
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return priorityRank(tasks[i].Priority) < priorityRank(tasks[j].Priority)
+		})
+	case sortDue:
+		sort.SliceStable(tasks, func(i, j int) bool {
+			if tasks[j].Deadline.IsZero() {
+				return !tasks[i].Deadline.IsZero()
+			}
+			return !tasks[i].Deadline.IsZero() && tasks[i].Deadline.Before(tasks[j].Deadline)
+		})
+	}
+}
+
+//taskPage is a page of a filtered task list
+type taskPage struct {
+	Filter TaskFilter
+	Page   int
+}
+
+//encode write the page as callback data, eg: "2|doing|halink0803|3|week|P1|backend|due"
+//Empty trailing fields are left out to stay under the 64 bytes telegram allows,
+//pageData saves longer filters.
+func (p taskPage) encode() string {
+	project := ""
+	if p.Filter.ProjectID != 0 {
+		project = strconv.Itoa(p.Filter.ProjectID)
+	}
+	data := strings.Join([]string{strconv.Itoa(p.Page), p.Filter.Status, p.Filter.Assignee, project, p.Filter.Due,
+		p.Filter.Priority, p.Filter.Label, p.Filter.Sort}, "|")
+	return strings.TrimRight(data, "|")
+}
+
+//pageData write a page as callback data, saving its filter and writing its
+//id instead when the page is too long for a button
+func (b Bot) pageData(page taskPage) string {
+	data := page.encode()
+	if len(data) <= maxPageData {
+		return data
+	}
+	filterID, err := b.storage.SaveFilter(taskPage{Filter: page.Filter}.encode())
+	if err != nil {
+		return taskPage{Page: page.Page}.encode()
+	}
+	return fmt.Sprintf("%s%d|%d", savedPagePrefix, filterID, page.Page)
+}
+
+//decodePageData read the callback data written by pageData
+func (b Bot) decodePageData(data string) (taskPage, error) {
+	if !strings.HasPrefix(data, savedPagePrefix) {
+		return decodeTaskPage(data)
+	}
+	parts := strings.Split(strings.TrimPrefix(data, savedPagePrefix), "|")
+	if len(parts) != 2 {
+		return taskPage{}, errBadTaskPage
+	}
+	filterID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return taskPage{}, errBadTaskPage
+	}
+	pageNumber, err := strconv.Atoi(parts[1])
+	if err != nil || pageNumber < 0 {
+		return taskPage{}, errBadTaskPage
+	}
+	filter, err := b.storage.GetFilter(filterID)
+	if err != nil {
+		return taskPage{}, errBadTaskPage
+	}
+	page, err := decodeTaskPage(filter)
+	page.Page = pageNumber
+	return page, err
+}
+
+func decodeTaskPage(data string) (taskPage, error) {
+	parts := append(strings.Split(data, "|"), "", "", "", "", "", "", "")
+	page, err := strconv.Atoi(parts[0])
+	if err != nil || page < 0 {
+		return taskPage{}, errBadTaskPage
+	}
+	projectID := 0
+	if parts[3] != "" {
+		projectID, err = strconv.Atoi(parts[3])
+		if err != nil {
+			return taskPage{}, errBadTaskPage
+		}
+	}
+	return taskPage{
+		Filter: TaskFilter{
+			Status:    parts[1],
+			Assignee:  parts[2],
+			ProjectID: projectID,
+			Due:       parts[4],
+			Priority:  parts[5],
+			Label:     parts[6],
+			Sort:      parts[7],
+		},
+		Page: page,
+	}, nil
+}
+
+//filterWorkflow return the workflow giving the states of a filter,
+//the one of the filtered project or else of the default project of the chat
+func (b Bot) filterWorkflow(chatID int64, filter TaskFilter) Workflow {
+	if filter.ProjectID != 0 {
+		return workflowOf(b.storage, filter.ProjectID)
+	}
+	defaultProject, _ := b.storage.GetDefaultProject(chatID)
+	return workflowOf(b.storage, defaultProject.ProjectID)
+}
+
+//taskMatchers build the query of the tasks of a chat matching a filter
+func (b Bot) taskMatchers(chatID int64, filter TaskFilter) ([]q.Matcher, error) {
+	scope, err := b.chatScope(chatID)
+	if err != nil {
+		return nil, err
+	}
+	matchers := filter.matchers(b.filterWorkflow(chatID, filter), b.locale(chatID).now())
+	return append(matchers, scope), nil
+}
+
+//findTasks return the tasks of a chat matching a filter, in its order
+func (b Bot) findTasks(chatID int64, filter TaskFilter) ([]TaskDB, error) {
+	matchers, err := b.taskMatchers(chatID, filter)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := b.storage.FindTasks(matchers...)
+	if err != nil {
+		return nil, err
+	}
+	sortTasks(tasks, filter.Sort)
+	return tasks, nil
+}
+
+//describeFilter tell which tasks a filter selects, eg: "*doing* tasks of @halink0803 due this week"
+func (b Bot) describeFilter(chatID int64, filter TaskFilter) string {
+	if filter == (TaskFilter{}) {
+		return "All tasks"
+	}
+	message := "Tasks"
+	if filter.Status != "" {
+		message = fmt.Sprintf("*%s* tasks", escapeMarkdown(filter.Status))
+	}
+	if filter.Assignee != "" {
+		message += fmt.Sprintf(" of %s", escapeMarkdown("@"+filter.Assignee))
+	}
+	if filter.ProjectID != 0 {
+		project, _ := b.storage.GetChatProject(chatID, filter.ProjectID)
+		message += fmt.Sprintf(" in *%s*", orNone(escapeMarkdown(project.Title)))
+	}
+	switch filter.Due {
+	case dueOverdue:
+		message += " overdue"
+	case dueToday:
+		message += " due today"
+	case dueWeek:
+		message += " due this week"
+	case dueNone:
+		message += " without deadline"
+	}
+	if filter.Priority != "" {
+		message += fmt.Sprintf(" with priority *%s*", escapeMarkdown(filter.Priority))
+	}
+	if filter.Label != "" {
+		message += " labeled " + escapeMarkdown("#"+filter.Label)
+	}
+	switch filter.Sort {
+	case sortPriority:
+		message += ", by priority"
+	case sortDue:
+		message += ", by deadline"
+	}
+	return message
+}
+
+//nextSortOrder is the order the sort button of a list switches to
+func nextSortOrder(order string) string {
+	for i, other := range sortOrders {
+		if other == order {
+			if i+1 < len(sortOrders) {
+				return sortOrders[i+1]
+			}
+			return ""
+		}
+	}
+	return sortOrders[0]
+}
+
+//sortOrderName is the text of the sort button switching to an order
+func sortOrderName(order string) string {
+	switch order {
+	case sortPriority:
+		return "By priority"
+	case sortDue:
+		return "By deadline"
+	}
+	return "By id"
+}
+
+//checked mark the button of the active choice of a filter
+func checked(text string, active bool) string {
+	if active {
+		return "✓ " + text
+	}
+	return text
+}
+
+//renderTaskPage build the text and buttons of a page of tasks,
+//and return the tasks shown
+func (b Bot) renderTaskPage(chatID int64, page taskPage) (string, [][]tb.InlineButton, []TaskDB, error) {
+	tasks, err := b.findTasks(chatID, page.Filter)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	pages := (len(tasks) + tasksPerPage - 1) / tasksPerPage
+	if pages == 0 {
+		pages = 1
+	}
+	if page.Page >= pages {
+		page.Page = pages - 1
+	}
+	start := page.Page * tasksPerPage
+	end := start + tasksPerPage
+	if end > len(tasks) {
+		end = len(tasks)
+	}
+
+	locale := b.locale(chatID)
+	message := fmt.Sprintf("%s, page %d/%d:\n", b.describeFilter(chatID, page.Filter), page.Page+1, pages)
+	if len(tasks) == 0 {
+		message += "There is no task for show\n"
+	}
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	for _, task := range tasks[start:end] {
+		message += b.taskCardText(task, locale) + "\n"
+		row = append(row, taskActionButton(fmt.Sprintf("%s %s", b.taskKey(task), shorten(task.Title, maxButtonTitle)),
+			actionDetails, task.ID))
+		if len(row) == 2 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+
+	navigation := []tb.InlineButton{}
+	if page.Page > 0 {
+		previous := taskPage{Filter: page.Filter, Page: page.Page - 1}
+		navigation = append(navigation, dataButton(taskPageBtn, "« Prev", b.pageData(previous)))
+	}
+	if page.Page < pages-1 {
+		next := taskPage{Filter: page.Filter, Page: page.Page + 1}
+		navigation = append(navigation, dataButton(taskPageBtn, "Next »", b.pageData(next)))
+	}
+	if len(navigation) > 0 {
+		keys = append(keys, navigation)
+	}
+
+	filter := page.Filter
+	filter.Status = ""
+	statuses := []tb.InlineButton{dataButton(taskPageBtn, checked("All", page.Filter.Status == ""), b.pageData(taskPage{Filter: filter}))}
+	for _, state := range b.filterWorkflow(chatID, page.Filter).States {
+		filter.Status = state
+		statuses = append(statuses, dataButton(taskPageBtn, checked(state, page.Filter.Status == state), b.pageData(taskPage{Filter: filter})))
+	}
+	keys = append(keys, statuses)
+
+	current := b.pageData(taskPage{Filter: page.Filter})
+	keys = append(keys, []tb.InlineButton{
+		dataButton(taskFilterBtn, checked("👤 Assignee", page.Filter.Assignee != ""), filterAssignee+"|"+current),
+		dataButton(taskFilterBtn, checked("📁 Project", page.Filter.ProjectID != 0), filterProject+"|"+current),
+		dataButton(taskFilterBtn, checked("📅 Deadline", page.Filter.Due != ""), filterDue+"|"+current),
+	})
+	sorted := page.Filter
+	sorted.Sort = nextSortOrder(page.Filter.Sort)
+	keys = append(keys, []tb.InlineButton{
+		dataButton(taskFilterBtn, checked("⚡ Priority", page.Filter.Priority != ""), filterPriority+"|"+current),
+		dataButton(taskFilterBtn, checked("🏷 Label", page.Filter.Label != ""), filterLabel+"|"+current),
+		dataButton(taskPageBtn, "↕ "+sortOrderName(sorted.Sort), b.pageData(taskPage{Filter: sorted})),
+	})
+	if page.Filter != (TaskFilter{}) {
+		keys = append(keys, []tb.InlineButton{dataButton(taskPageBtn, "✖ Clear filters", b.pageData(taskPage{}))})
+	}
+	return message, keys, tasks[start:end], nil
+}
+
+//renderFilterChoices build the buttons picking the value of one filter of a list
+func (b Bot) renderFilterChoices(chatID int64, kind string, page taskPage) (string, [][]tb.InlineButton, error) {
+	type choice struct {
+		text   string
+		filter TaskFilter
+	}
+	choices := []choice{}
+	filter := page.Filter
+	message := ""
+	switch kind {
+	case filterAssignee:
+		filter.Assignee = ""
+		matchers, err := b.taskMatchers(chatID, filter)
+		if err != nil {
+			return "", nil, err
+		}
+		assignees, err := b.storage.GetTaskAssignees(matchers...)
+		if err != nil {
+			return "", nil, err
+		}
+		message = "Show the tasks of:"
+		if len(assignees) == 0 {
+			message = "Nobody is assigned to these tasks yet"
+		}
+		choices = append(choices, choice{checked("Everyone", page.Filter.Assignee == ""), filter})
+		for _, assignee := range assignees {
+			filter.Assignee = assignee
+			choices = append(choices, choice{checked("@"+assignee, page.Filter.Assignee == assignee), filter})
+		}
+	case filterProject:
+		projects, err := b.storage.GetChatProjects(chatID)
+		if err != nil {
+			return "", nil, err
+		}
+		message = "Show the tasks in:"
+		filter.ProjectID = 0
+		choices = append(choices, choice{checked("All projects", page.Filter.ProjectID == 0), filter})
+		for _, project := range projects {
+			filter.ProjectID = project.ID
+			choices = append(choices, choice{checked(project.Title, page.Filter.ProjectID == project.ID), filter})
+		}
+	case filterPriority:
+		message = "Show the tasks with priority:"
+		filter.Priority = ""
+		choices = append(choices, choice{checked("Any priority", page.Filter.Priority == ""), filter})
+		for _, priority := range priorities {
+			filter.Priority = priority
+			choices = append(choices, choice{checked(priority, page.Filter.Priority == priority), filter})
+		}
+	case filterLabel:
+		filter.Label = ""
+		matchers, err := b.taskMatchers(chatID, filter)
+		if err != nil {
+			return "", nil, err
+		}
+		labels, err := b.storage.GetTaskLabels(matchers...)
+		if err != nil {
+			return "", nil, err
+		}
+		message = "Show the tasks labeled:"
+		if len(labels) == 0 {
+			message = "These tasks have no label yet"
+		}
+		choices = append(choices, choice{checked("Any label", page.Filter.Label == ""), filter})
+		for _, label := range labels {
+			filter.Label = label
+			choices = append(choices, choice{checked("#"+label, page.Filter.Label == label), filter})
+		}
+	case filterDue:
+		message = "Show the tasks due:"
+		filter.Due = ""
+		choices = append(choices, choice{checked("Any time", page.Filter.Due == ""), filter})
+		for _, due := range dueWindows {
+			filter.Due = due
+			choices = append(choices, choice{checked(due, page.Filter.Due == due), filter})
+		}
+	default:
+		return "", nil, errBadTaskPage
+	}
+
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	for _, choice := range choices {
+		row = append(row, dataButton(taskPageBtn, shorten(choice.text, maxButtonTitle), b.pageData(taskPage{Filter: choice.filter})))
+		if len(row) == 2 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	keys = append(keys, []tb.InlineButton{dataButton(taskPageBtn, "« Back", b.pageData(page))})
+	return message, keys, nil
+}
+
+func shorten(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+func (b Bot) handleListTask(m *tb.Message) {
+	filter, err := parseTaskFilter(m.Payload)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get task list: %s", err.Error()))
+		return
+	}
+	if workflow := b.filterWorkflow(m.Chat.ID, filter); filter.Status != "" && !workflow.Has(filter.Status) {
+		b.bot.Reply(m, fmt.Sprintf("Unknown state %s, states are: %s", filter.Status, strings.Join(workflow.States, ", ")))
+		return
+	}
+	b.replyTaskPage(m, taskPage{Filter: filter})
+}
+
+func (b Bot) handleListTaskByAssignee(m *tb.Message) {
+	if mentions := mentionedUsernames(m); len(mentions) > 0 {
+		b.replyTaskPage(m, taskPage{Filter: TaskFilter{Assignee: mentions[len(mentions)-1]}})
+		return
+	}
+	message, keys, err := b.renderFilterChoices(m.Chat.ID, filterAssignee, taskPage{})
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get assignees: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, message, &tb.ReplyMarkup{InlineKeyboard: keys})
+}
+
+//replyTaskPage send a page of tasks as a new list message
+func (b Bot) replyTaskPage(m *tb.Message, page taskPage) {
+	message, keys, tasks, err := b.renderTaskPage(m.Chat.ID, page)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot get task list: %s", err.Error()))
+		return
+	}
+	sent, err := b.bot.Reply(m, message, &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+	if err == nil {
+		b.rememberTasks(sent, tasks...)
+	}
+}
+
+//handleTaskPage show another page of a task list in the same message
+func (b Bot) handleTaskPage(c *tb.Callback) {
+	page, err := b.decodePageData(c.Data)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	message, keys, tasks, err := b.renderTaskPage(c.Message.Chat.ID, page)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Cannot get task list: %s", err.Error())})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	_, err = b.bot.Edit(c.Message, message, &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+	if err == nil {
+		b.rememberTasks(c.Message, tasks...)
+	}
+}
+
+//handleTaskFilter show the choices of a filter in place of the task list
+func (b Bot) handleTaskFilter(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, "|", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	page, err := b.decodePageData(parts[1])
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	message, keys, err := b.renderFilterChoices(c.Message.Chat.ID, parts[0], page)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Cannot get filter: %s", err.Error())})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.bot.Edit(c.Message, message, &tb.ReplyMarkup{InlineKeyboard: keys})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageData(t *testing.T) {
+	b := Bot{storage: newTestStorage(t)}
+	pages := []taskPage{
+		{},
+		{Page: 2, Filter: TaskFilter{Status: "doing", Assignee: "halink0803", Due: dueWeek}},
+		{Page: 1, Filter: TaskFilter{Status: "waiting_for_review", Assignee: "a_rather_long_username_here",
+			ProjectID: 12, Due: dueOverdue, Priority: "P1", Label: "infrastructure", Sort: sortPriority}},
+		{Filter: TaskFilter{Label: strings.Repeat("é", 20), Assignee: "a_rather_long_username_here"}},
+	}
+	for _, page := range pages {
+		data := b.pageData(page)
+		if len(taskFilterBtn.Unique)+len(filterAssignee)+len(data)+3 > 64 {
+			t.Errorf("pageData(%+v) = %q is too long for a button", page, data)
+		}
+		got, err := b.decodePageData(data)
+		if err != nil {
+			t.Errorf("decodePageData(%q) failed: %s", data, err)
+			continue
+		}
+		if got != page {
+			t.Errorf("decodePageData(%q) = %+v, want %+v", data, got, page)
+		}
+	}
+	if b.pageData(pages[2]) != b.pageData(pages[2]) {
+		t.Errorf("a long filter is saved again instead of reused")
+	}
+}
+
+func TestDecodePageDataErrors(t *testing.T) {
+	b := Bot{storage: newTestStorage(t)}
+	for _, data := range []string{"x", "-1", "1|doing|an|x", "~", "~1", "~x|0", "~1|0"} {
+		if page, err := b.decodePageData(data); err == nil {
+			t.Errorf("decodePageData(%q) = %+v, want an error", data, page)
+		}
+	}
+}
+
+func TestDescribeFilterEscapesMarkdown(t *testing.T) {
+	b := Bot{storage: newTestStorage(t)}
+	project, err := b.storage.StoreProject(Project{Title: "db_backup"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := b.describeFilter(1, TaskFilter{Status: "waiting_for_review", Assignee: "john_doe", ProjectID: project.ID})
+	for _, text := range []string{"waiting_for_review", "@john_doe", "db_backup"} {
+		if !strings.Contains(header, escapeMarkdown(text)) {
+			t.Errorf("header %q does not escape %q", header, text)
+		}
+	}
+}
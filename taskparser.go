@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// The single-line task syntax is
+//
+//	line     = title *( sep field )
+//	sep      = spaces "-" spaces
+//	field    = tags | deadline | text
+//	tags     = tag *( spaces tag )
+//	tag      = "@" username | "!" priority | "#" label
+//	priority = P0 to P3 or urgent, high, medium, low
+//	deadline = a date the chat locale understands, such as 12/04 or tomorrow 5pm
+//
+// A hyphen only separates fields when it has whitespace on both sides, so
+// titles like "Re-run migration" are kept whole. The first free text field
+// after the title is the description; any further text fields are joined to
+// it with " - " so nothing the user typed is lost. Tags assign the task,
+// give it a priority and label it, eg: "Fix login - @an !high #backend".
+
+var (
+	errEmptyTitle        = errors.New("task title is required")
+	errDuplicateDeadline = errors.New("only one deadline is allowed")
+	errDuplicatePriority = errors.New("only one !priority is allowed")
+
+	fieldSeparator = regexp.MustCompile(`\s+-\s+`)
+	mentionPattern = regexp.MustCompile(`^@\w{1,32}$`)
+)
+
+//parseTaskLine parse a task written in the single-line syntax
+func parseTaskLine(line string, locale DateLocale) (Task, error) {
+	var task Task
+	segments := fieldSeparator.Split(strings.TrimSpace(line), -1)
+	task.Title = strings.TrimSpace(segments[0])
+	if task.Title == "" || strings.HasPrefix(task.Title, "-") {
+		return task, errEmptyTitle
+	}
+	descriptions := []string{}
+	for _, segment := range segments[1:] {
+		segment = strings.TrimSpace(segment)
+		switch {
+		case segment == "":
+			continue
+		case isTagSegment(segment):
+			for _, tag := range strings.Fields(segment) {
+				switch {
+				case mentionPattern.MatchString(tag):
+					task.Assignees = addPeople(task.Assignees, Person{Name: strings.TrimPrefix(tag, "@")})
+				case isPriorityWord(tag):
+					if task.Priority != "" {
+						return task, errDuplicatePriority
+					}
+					task.Priority, _ = parsePriority(tag)
+				default:
+					if label := normalizeLabel(tag); !contains(task.Labels, label) {
+						task.Labels = append(task.Labels, label)
+					}
+				}
+			}
+		default:
+			deadline, err := locale.Parse(segment)
+			if err != nil || len(descriptions) > 0 {
+				descriptions = append(descriptions, segment)
+				continue
+			}
+			if !task.Deadline.IsZero() {
+				return task, errDuplicateDeadline
+			}
+			task.Deadline = deadline
+		}
+	}
+	task.Description = strings.Join(descriptions, " - ")
+	return task, nil
+}
+
+//isTagSegment tell whether every word of a field is a tag
+func isTagSegment(segment string) bool {
+	for _, word := range strings.Fields(segment) {
+		if !mentionPattern.MatchString(word) && !isPriorityWord(word) && !labelPattern.MatchString(word) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTaskLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Task
+	}{
+		{"Fix login", Task{Title: "Fix login"}},
+		{"  Re-run migration  ", Task{Title: "Re-run migration"}},
+		{"Fix login - @an", Task{Title: "Fix login", Assignees: []Person{{Name: "an"}}}},
+		{"Fix login - @an @binh @an", Task{Title: "Fix login", Assignees: []Person{{Name: "an"}, {Name: "binh"}}}},
+		{"Fix login - !high #Backend #api #backend", Task{Title: "Fix login", Priority: "P1", Labels: []string{"backend", "api"}}},
+		{"Fix login - @an !P0 - 12/04 - Users cannot log in", Task{
+			Title:       "Fix login",
+			Assignees:   []Person{{Name: "an"}},
+			Priority:    "P0",
+			Deadline:    time.Date(2019, 4, 12, 23, 59, 0, 0, time.UTC),
+			Description: "Users cannot log in",
+		}},
+		{"Fix login - tomorrow 5pm", Task{Title: "Fix login", Deadline: time.Date(2018, 12, 29, 17, 0, 0, 0, time.UTC)}},
+		{"Fix login - Users cannot log in - on mobile", Task{Title: "Fix login", Description: "Users cannot log in - on mobile"}},
+		// a date after the description belongs to it
+		{"Fix login - Broken since - monday", Task{Title: "Fix login", Description: "Broken since - monday"}},
+		{"Fix login - @an and others", Task{Title: "Fix login", Description: "@an and others"}},
+	}
+	for _, test := range tests {
+		got, err := parseTaskLine(test.line, testLocale(true))
+		if err != nil {
+			t.Errorf("parseTaskLine(%q) failed: %s", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTaskLine(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseTaskLineErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		err  error
+	}{
+		{"", errEmptyTitle},
+		{"   ", errEmptyTitle},
+		{" - @an", errEmptyTitle},
+		{"Fix login - 12/04 - tomorrow", errDuplicateDeadline},
+		{"Fix login - !high - !low", errDuplicatePriority},
+		{"Fix login - !high !p2", errDuplicatePriority},
+	}
+	for _, test := range tests {
+		if _, err := parseTaskLine(test.line, testLocale(true)); err != test.err {
+			t.Errorf("parseTaskLine(%q) error = %v, want %v", test.line, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// A task is referred to by its id, eg: 42, or by its key made of the key of
+// its project and its number in the project, eg: OPS-42.
+
+var taskKeyPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)-(\d+)$`)
+
+var projectKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+// maxProjectKey is the length of keys made from project titles
+const maxProjectKey = 4
+
+var errNotTaskMessage = errors.New("this message is not about a task")
+
+var errManyTasks = errors.New("this message is about several tasks, reply to one of them or give its key")
+
+//projectKeyOf make a key from a project title, from the initials of its
+//words or the first letters of a single word, eg: "Mobile App" is MA
+func projectKeyOf(title string) string {
+	words := strings.FieldsFunc(strings.ToUpper(title), func(r rune) bool {
+		return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	key := ""
+	if len(words) > 1 {
+		for _, word := range words {
+			key += word[:1]
+		}
+	} else if len(words) == 1 {
+		key = words[0]
+	}
+	key = strings.TrimLeftFunc(key, unicode.IsDigit)
+	if len(key) > maxProjectKey {
+		key = key[:maxProjectKey]
+	}
+	if len(key) < 2 {
+		return "TASK"
+	}
+	return key
+}
+
+//taskKey return the key of a task, or its id when it has none
+func (b Bot) taskKey(task TaskDB) string {
+	project, err := b.storage.GetProject(task.ProjectID)
+	if err != nil || project.Key == "" || task.Number == 0 {
+		return strconv.Itoa(task.ID)
+	}
+	return fmt.Sprintf("%s-%d", project.Key, task.Number)
+}
+
+//findTask get a task visible in a chat by its id or its key
+func (b Bot) findTask(chatID int64, ref string) (TaskDB, error) {
+	if taskID, err := strconv.Atoi(ref); err == nil {
+		return b.storage.GetChatTask(chatID, taskID)
+	}
+	match := taskKeyPattern.FindStringSubmatch(ref)
+	if match == nil {
+		return TaskDB{}, fmt.Errorf("%s is not a task id or key, eg: 42 or OPS-42", ref)
+	}
+	project, err := b.storage.GetProjectByKey(strings.ToUpper(match[1]))
+	if err != nil {
+		return TaskDB{}, fmt.Errorf("no project has the key %s", strings.ToUpper(match[1]))
+	}
+	number, _ := strconv.Atoi(match[2])
+	task, err := b.storage.GetTaskByNumber(project.ID, number)
+	if err != nil {
+		return TaskDB{}, fmt.Errorf("there is no task %s", ref)
+	}
+	return b.storage.GetChatTask(chatID, task.ID)
+}
+
+//repliedTask get the task a message replies to
+//Messages the bot sent about a task are remembered, messages it sent before
+//that start with the id of their task. What people write is never a task.
+func (b Bot) repliedTask(m *tb.Message) (TaskDB, error) {
+	if !m.IsReply() {
+		return TaskDB{}, errNotTaskMessage
+	}
+	message, err := b.storage.GetTaskMessage(m.Chat.ID, m.ReplyTo.ID)
+	if err == nil && len(message.TaskIDs) == 1 {
+		return b.storage.GetChatTask(m.Chat.ID, message.TaskIDs[0])
+	}
+	if err == nil && len(message.TaskIDs) > 1 {
+		return TaskDB{}, errManyTasks
+	}
+	if m.ReplyTo.Sender == nil || m.ReplyTo.Sender.ID != b.bot.Me.ID {
+		return TaskDB{}, errNotTaskMessage
+	}
+	words := strings.Fields(m.ReplyTo.Text)
+	if len(words) == 0 {
+		return TaskDB{}, errNotTaskMessage
+	}
+	task, err := b.findTask(m.Chat.ID, words[0])
+	if err != nil {
+		return TaskDB{}, errNotTaskMessage
+	}
+	return task, nil
+}
+
+//targetTask get the task a command is about, the replied task or the task
+//whose id or key is the first word of the command, and the words left
+func (b Bot) targetTask(m *tb.Message) (TaskDB, []string, error) {
+	args := strings.Fields(m.Payload)
+	if m.IsReply() {
+		task, err := b.repliedTask(m)
+		return task, args, err
+	}
+	if len(args) == 0 {
+		return TaskDB{}, nil, errors.New("reply to a task or give its id or key")
+	}
+	task, err := b.findTask(m.Chat.ID, args[0])
+	return task, args[1:], err
+}
+
+//rememberTasks record which tasks a sent message is about
+func (b Bot) rememberTasks(message *tb.Message, tasks ...TaskDB) {
+	if message == nil {
+		return
+	}
+	taskIDs := []int{}
+	for _, task := range tasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+	b.storage.StoreTaskMessage(message.Chat.ID, message.ID, taskIDs)
+}
+
+//sendAbout send a message about a task and remember it
+func (b Bot) sendAbout(task TaskDB, to tb.Recipient, what interface{}, options ...interface{}) (*tb.Message, error) {
+	sent, err := b.bot.Send(to, what, options...)
+	if err == nil {
+		b.rememberTasks(sent, task)
+	}
+	return sent, err
+}
+
+//replyAbout reply with a message about a task and remember it
+func (b Bot) replyAbout(task TaskDB, m *tb.Message, what interface{}, options ...interface{}) (*tb.Message, error) {
+	sent, err := b.bot.Reply(m, what, options...)
+	if err == nil {
+		b.rememberTasks(sent, task)
+	}
+	return sent, err
+}
+
+//handleProjectKey show or change the key of the tasks of the default project
+func (b Bot) handleProjectKey(m *tb.Message) {
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, err := b.storage.GetChatProject(m.Chat.ID, defaultProject.ProjectID)
+	if err != nil {
+		b.bot.Reply(m, "Set a default project first with /set_default_project")
+		return
+	}
+	key := strings.ToUpper(strings.TrimSpace(m.Payload))
+	if key == "" {
+		b.bot.Reply(m, fmt.Sprintf("Tasks of *%s* are %s-1, %s-2… Change it with eg: /project\\_key OPS",
+			project.Title, project.Key, project.Key), tb.ModeMarkdown)
+		return
+	}
+	if !projectKeyPattern.MatchString(key) {
+		b.bot.Reply(m, "A project key is 2 to 10 letters or digits starting with a letter, eg: OPS")
+		return
+	}
+	if other, err := b.storage.GetProjectByKey(key); err == nil && other.ID != project.ID {
+		b.bot.Reply(m, fmt.Sprintf("Another project already has the key %s", key))
+		return
+	}
+	project.Key = key
+	if err := b.storage.UpdateProject(project); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot change project key: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Tasks of *%s* now are %s-1, %s-2…", project.Title, key, key), tb.ModeMarkdown)
+}
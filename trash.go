@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// defaultTrashRetention is how long deleted tasks can be restored when the
+// config does not set it
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often tasks deleted for longer than the retention are removed
+const trashPurgeInterval = time.Hour
+
+// restoreBtn brings a deleted task back, its data is the task id
+var restoreBtn = tb.InlineButton{Unique: "task_restore"}
+
+// errOpenSubtasks refuses to delete a task whose subtasks are not done
+var errOpenSubtasks = errors.New("it has open subtasks, close or delete them first")
+
+//trashTask delete a task into the trash and return the message telling it,
+//with a button to undo. Tasks with open subtasks are kept, the done subtasks
+//of a deleted task stop being its subtasks.
+func (b Bot) trashTask(task TaskDB, m *tb.Message) (string, *tb.SendOptions, error) {
+	for _, child := range b.subtasks(task) {
+		if !isClosed(b.storage, child) {
+			return "", nil, errOpenSubtasks
+		}
+	}
+	trashed, err := b.storage.TrashTask(task, actorOf(m), time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	message := fmt.Sprintf("Deleted %s *%s*, /undo restores it for %s",
+		b.taskKey(task), escapeMarkdown(task.Title), humanDuration(b.trashRetention))
+	return message, &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: [][]tb.InlineButton{{
+			dataButton(restoreBtn, "↩️ Undo", strconv.Itoa(trashed.ID)),
+		}}},
+	}, nil
+}
+
+//handleDelete delete the replied task, or the task given by its id or key
+func (b Bot) handleDelete(m *tb.Message) {
+	task, _, err := b.targetTask(m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot delete task: %s", err.Error()))
+		return
+	}
+	if !b.permitted(m, task.ProjectID, permDeleteTasks) {
+		return
+	}
+	message, options, err := b.trashTask(task, m)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot delete task: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, message, options)
+}
+
+//restorable get the deleted tasks of the projects of a chat that can still be restored
+func (b Bot) restorable(chatID int64, matchers ...q.Matcher) ([]TrashedTask, error) {
+	scope, err := b.chatScope(chatID)
+	if err != nil {
+		return nil, err
+	}
+	matchers = append(matchers, scope, q.Gte("DeletedAt", time.Now().Add(-b.trashRetention)))
+	return b.storage.GetTrash(matchers...)
+}
+
+//restoreTask bring a deleted task back and tell the chat
+func (b Bot) restoreTask(trashed TrashedTask, m *tb.Message) {
+	if !b.permitted(m, trashed.ProjectID, permDeleteTasks) {
+		return
+	}
+	task, err := b.storage.RestoreTask(trashed, actorOf(m))
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot restore task: %s", err.Error()))
+		return
+	}
+	b.sendAbout(task, m.Chat, fmt.Sprintf("Restored %s *%s*", b.taskKey(task), escapeMarkdown(task.Title)), tb.ModeMarkdown)
+}
+
+//handleUndo restore the task deleted last in this chat
+func (b Bot) handleUndo(m *tb.Message) {
+	trash, err := b.restorable(m.Chat.ID, q.Eq("ChatID", m.Chat.ID))
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot restore task: %s", err.Error()))
+		return
+	}
+	if len(trash) == 0 {
+		b.bot.Reply(m, "There is no deleted task to restore")
+		return
+	}
+	b.restoreTask(trash[0], m)
+}
+
+//handleRestore restore a deleted task given by its id or key,
+//or list the deleted tasks with a button to restore each
+func (b Bot) handleRestore(m *tb.Message) {
+	trash, err := b.restorable(m.Chat.ID)
+	if err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot restore task: %s", err.Error()))
+		return
+	}
+	if ref := strings.TrimSpace(m.Payload); ref != "" {
+		for _, trashed := range trash {
+			if strconv.Itoa(trashed.ID) == ref || strings.EqualFold(b.taskKey(trashed.Task), ref) {
+				b.restoreTask(trashed, m)
+				return
+			}
+		}
+		b.bot.Reply(m, fmt.Sprintf("There is no deleted task %s to restore", ref))
+		return
+	}
+	if len(trash) == 0 {
+		b.bot.Reply(m, "There is no deleted task to restore")
+		return
+	}
+	locale := b.locale(m.Chat.ID)
+	message := fmt.Sprintf("Deleted tasks, kept for %s:\n", humanDuration(b.trashRetention))
+	keys := [][]tb.InlineButton{}
+	for _, trashed := range trash {
+		key := b.taskKey(trashed.Task)
+		message += fmt.Sprintf("%s *%s* deleted by %s %s\n", key, escapeMarkdown(trashed.Task.Title),
+			escapeMarkdown(trashed.DeletedBy), locale.Format(trashed.DeletedAt))
+		keys = append(keys, []tb.InlineButton{
+			dataButton(restoreBtn, fmt.Sprintf("↩️ %s %s", key, shorten(trashed.Task.Title, maxButtonTitle)), strconv.Itoa(trashed.ID)),
+		})
+	}
+	b.bot.Reply(m, message, &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//handleRestoreButton restore the deleted task of the button
+func (b Bot) handleRestoreButton(c *tb.Callback) {
+	taskID, _ := strconv.Atoi(c.Data)
+	trash, err := b.restorable(c.Message.Chat.ID, q.Eq("ID", taskID))
+	if err != nil || len(trash) == 0 {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task cannot be restored anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, trash[0].ProjectID, permDeleteTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.restoreTask(trash[0], callbackMessage(c))
+}
+
+//emptyTrash remove for good the tasks deleted for longer than the retention, every interval
+func (b Bot) emptyTrash(interval time.Duration) {
+	for {
+		purged, err := b.storage.PurgeTrash(time.Now().Add(-b.trashRetention))
+		if err == nil && purged > 0 {
+			log.Printf("Removed %d tasks deleted more than %s ago", purged, b.trashRetention)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
+import "time"
+
 // Task object
 type Task struct {
-	Title       string `json:"title"`
-	Assigned    string `json:"assigned"`
-	Deadline    string `json:"deadline"`
-	Status      string `json:"status"`
-	Discussion  string `json:"discussion"`
-	Description string `json:"description"`
+	Title       string    `json:"title"`
+	Assignees   []Person  `json:"assignees"`
+	Priority    string    `json:"priority"`
+	Labels      []string  `json:"labels"`
+	ParentID    int       `json:"parent_id"`
+	Deadline    time.Time `json:"deadline"`
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	CreatorID   int       `json:"creator_id"`
+	Creator     string    `json:"creator"`
 }
 
 // Project object
 type Project struct {
-	Title   string `json:"title"`
-	Creator string `json:"creator"`
-	Status  string `json:"status"`
+	Title     string `json:"title"`
+	CreatorID int    `json:"creator_id"`
+	Creator   string `json:"creator"`
+	Status    string `json:"status"`
 }
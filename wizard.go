@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// recentAssigneeLimit is how many assignees are offered in the wizard
+const recentAssigneeLimit = 6
+
+// wizardDateLayout is how the date picker writes deadlines,
+// the locale reads it back as the end of that day
+const wizardDateLayout = "2006-01-02"
+
+// Inline buttons of the task creation wizard. The handlers are registered
+// once in main, the value of each pressed button travels in its Data.
+var (
+	wizardAssigneeBtn = tb.InlineButton{Unique: "wizard_assignee"}
+	wizardDeadlineBtn = tb.InlineButton{Unique: "wizard_deadline"}
+	wizardCalendarBtn = tb.InlineButton{Unique: "wizard_calendar"}
+	wizardSkipBtn     = tb.InlineButton{Unique: "wizard_skip"}
+	wizardConfirmBtn  = tb.InlineButton{Unique: "wizard_confirm"}
+	wizardIgnoreBtn   = tb.InlineButton{Unique: "wizard_ignore"}
+)
+
+// wizardNone is the button data meaning "leave this field empty"
+const wizardNone = "-"
+
+func draftFromSession(session Session) Task {
+	deadline, _ := time.Parse(time.RFC3339, session.Data["deadline"])
+	return Task{
+		Title:       session.Data["title"],
+		Assignees:   decodePeople(session.Data["assignee"]),
+		Priority:    session.Data["priority"],
+		Labels:      strings.Fields(session.Data["labels"]),
+		Deadline:    deadline,
+		Description: session.Data["description"],
+	}
+}
+
+func storeDraft(session *Session, task Task) {
+	session.Data["title"] = task.Title
+	session.Data["assignee"] = encodePeople(task.Assignees)
+	session.Data["priority"] = task.Priority
+	session.Data["labels"] = strings.Join(task.Labels, " ")
+	session.Data["deadline"] = formatDraftDeadline(task.Deadline)
+	session.Data["description"] = task.Description
+}
+
+func formatDraftDeadline(deadline time.Time) string {
+	if deadline.IsZero() {
+		return ""
+	}
+	return deadline.Format(time.RFC3339)
+}
+
+//handleWizardText handle a text message sent while a task draft is open
+func (b Bot) handleWizardText(m *tb.Message, session Session) {
+	text := strings.TrimSpace(m.Text)
+	switch session.State {
+	case StateCreateTask:
+		if session.Data["editing"] != "" {
+			b.setDraftField(m, "title", text, nil)
+			return
+		}
+		task, err := parseTaskLine(text, b.locale(m.Chat.ID))
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot read task: %s", err.Error()))
+			return
+		}
+		if len(task.Assignees) == 0 && task.Deadline.IsZero() && task.Description == "" &&
+			task.Priority == "" && len(task.Labels) == 0 {
+			b.setDraftField(m, "title", task.Title, nil)
+			return
+		}
+		// the whole task was written on one line, go straight to confirm
+		b.sessions.Update(m, func(s *Session) {
+			storeDraft(s, task)
+			s.State = StateTaskConfirm
+		})
+		b.showTaskConfirm(m, nil)
+	case StateTaskAssignee:
+		people := b.mentionedPeople(m)
+		if fields := strings.Fields(text); len(people) == 0 && len(fields) > 0 {
+			people = []Person{b.knownPerson(Person{Name: strings.TrimPrefix(fields[0], "@")})}
+		}
+		b.setDraftField(m, "assignee", encodePeople(people), nil)
+	case StateTaskDeadline:
+		deadline, err := b.locale(m.Chat.ID).Parse(text)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Pick a date from the calendar, or %s", err.Error()))
+			return
+		}
+		b.setDraftField(m, "deadline", formatDraftDeadline(deadline), nil)
+	case StateTaskDescription:
+		b.setDraftField(m, "description", text, nil)
+	case StateTaskConfirm:
+		b.bot.Reply(m, "Use the buttons above to save or edit the task, or /cancel.")
+	}
+}
+
+//setDraftField store a field of the task draft and move to the next step
+//The next prompt edits the edit message when set, for button presses.
+func (b Bot) setDraftField(m *tb.Message, field, value string, edit *tb.Message) {
+	var next SessionState
+	err := b.sessions.Update(m, func(s *Session) {
+		s.Data[field] = value
+		next = nextWizardState(field)
+		if s.Data["editing"] != "" {
+			next = StateTaskConfirm
+			delete(s.Data, "editing")
+		}
+		s.State = next
+	})
+	if err != nil {
+		b.bot.Send(m.Chat, fmt.Sprintf("Cannot create task: %s", err.Error()))
+		return
+	}
+	b.askWizardStep(m, next, edit)
+}
+
+func nextWizardState(field string) SessionState {
+	switch field {
+	case "title":
+		return StateTaskAssignee
+	case "assignee":
+		return StateTaskDeadline
+	case "deadline":
+		return StateTaskDescription
+	}
+	return StateTaskConfirm
+}
+
+//askWizardStep prompt for the given step, editing edit in place when set
+func (b Bot) askWizardStep(m *tb.Message, state SessionState, edit *tb.Message) {
+	switch state {
+	case StateCreateTask:
+		b.sendWizard(m, edit, "Task title:", nil)
+	case StateTaskAssignee:
+		b.askAssignee(m, edit)
+	case StateTaskDeadline:
+		now := b.locale(m.Chat.ID).now()
+		b.sendWizard(m, edit, "Deadline? Pick a date or send one like \"tomorrow 5pm\".",
+			calendarKeyboard(now, now))
+	case StateTaskDescription:
+		b.sendWizard(m, edit, "Description? Send it or skip.", [][]tb.InlineButton{
+			{dataButton(wizardSkipBtn, "Skip", wizardNone)},
+		})
+	case StateTaskConfirm:
+		b.showTaskConfirm(m, edit)
+	}
+}
+
+func (b Bot) sendWizard(m *tb.Message, edit *tb.Message, text string, keys [][]tb.InlineButton) {
+	options := &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+	}
+	if keys != nil {
+		options.ReplyMarkup = &tb.ReplyMarkup{InlineKeyboard: keys}
+	}
+	if edit != nil {
+		b.bot.Edit(edit, text, options)
+		return
+	}
+	b.bot.Send(m.Chat, text, options)
+}
+
+func (b Bot) askAssignee(m *tb.Message, edit *tb.Message) {
+	keys := [][]tb.InlineButton{}
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	assignees, _ := b.storage.GetRecentAssignees(defaultProject.ProjectID, recentAssigneeLimit)
+	row := []tb.InlineButton{}
+	for _, assignee := range assignees {
+		row = append(row, dataButton(wizardAssigneeBtn, "@"+assignee, assignee))
+		if len(row) == 2 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	keys = append(keys, []tb.InlineButton{dataButton(wizardAssigneeBtn, "Nobody", wizardNone)})
+	b.sendWizard(m, edit, "Who should do it? Pick someone or @mention an user.", keys)
+}
+
+func (b Bot) showTaskConfirm(m *tb.Message, edit *tb.Message) {
+	task := draftFromSession(b.sessions.Get(m))
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, _ := b.storage.GetProject(defaultProject.ProjectID)
+	message := fmt.Sprintf("Create this task in *%s*?\n", escapeMarkdown(project.Title))
+	message += fmt.Sprintf("Title: *%s*\n", escapeMarkdown(task.Title))
+	message += fmt.Sprintf("Assignees: %s\n", orNone(escapeMarkdown(names(task.Assignees))))
+	message += fmt.Sprintf("Deadline: %s\n", orNone(b.locale(m.Chat.ID).Format(task.Deadline)))
+	message += fmt.Sprintf("Description: %s\n", orNone(escapeMarkdown(task.Description)))
+	if task.Priority != "" {
+		message += fmt.Sprintf("Priority: %s\n", escapeMarkdown(task.Priority))
+	}
+	if len(task.Labels) > 0 {
+		message += fmt.Sprintf("Labels: %s\n", escapeMarkdown(labelsText(task.Labels)))
+	}
+	b.sendWizard(m, edit, message, [][]tb.InlineButton{
+		{dataButton(wizardConfirmBtn, "✅ Save", "save")},
+		{
+			dataButton(wizardConfirmBtn, "Title", "title"),
+			dataButton(wizardConfirmBtn, "Assignee", "assignee"),
+		},
+		{
+			dataButton(wizardConfirmBtn, "Deadline", "deadline"),
+			dataButton(wizardConfirmBtn, "Description", "description"),
+		},
+		{dataButton(wizardConfirmBtn, "❌ Cancel", "cancel")},
+	})
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "_none_"
+	}
+	return value
+}
+
+//wizardCallback return the message of a wizard callback
+//when the presser is the one writing the draft in the expected state
+func (b Bot) wizardCallback(c *tb.Callback, states ...SessionState) (*tb.Message, bool) {
+	m := callbackMessage(c)
+	session := b.sessions.Get(m)
+	for _, state := range states {
+		if session.State == state {
+			return m, true
+		}
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{Text: "This draft is not yours or has expired"})
+	return m, false
+}
+
+func (b Bot) handleWizardAssignee(c *tb.Callback) {
+	m, ok := b.wizardCallback(c, StateTaskAssignee)
+	if !ok {
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	assignee := ""
+	if c.Data != wizardNone {
+		assignee = encodePeople([]Person{b.knownPerson(Person{Name: c.Data})})
+	}
+	b.setDraftField(m, "assignee", assignee, c.Message)
+}
+
+func (b Bot) handleWizardDeadline(c *tb.Callback) {
+	m, ok := b.wizardCallback(c, StateTaskDeadline)
+	if !ok {
+		return
+	}
+	deadline := ""
+	if c.Data != wizardNone {
+		day, err := b.locale(m.Chat.ID).Parse(c.Data)
+		if err != nil {
+			b.bot.Respond(c, &tb.CallbackResponse{Text: fmt.Sprintf("Pick another date: %s", err.Error()), ShowAlert: true})
+			return
+		}
+		deadline = formatDraftDeadline(day)
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setDraftField(m, "deadline", deadline, c.Message)
+}
+
+func (b Bot) handleWizardCalendar(c *tb.Callback) {
+	m, ok := b.wizardCallback(c, StateTaskDeadline)
+	if !ok {
+		return
+	}
+	month, err := time.Parse("2006-01", c.Data)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "Cannot show this month, send the deadline instead", ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	today := b.locale(m.Chat.ID).now()
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, today.Location())
+	b.sendWizard(m, c.Message, "Deadline? Pick a date or send one like \"tomorrow 5pm\".", calendarKeyboard(month, today))
+}
+
+func (b Bot) handleWizardSkip(c *tb.Callback) {
+	m, ok := b.wizardCallback(c, StateTaskDescription)
+	if !ok {
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setDraftField(m, "description", "", c.Message)
+}
+
+func (b Bot) handleWizardConfirm(c *tb.Callback) {
+	m, ok := b.wizardCallback(c, StateTaskConfirm)
+	if !ok {
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	switch c.Data {
+	case "save":
+		task := draftFromSession(b.sessions.Get(m))
+		b.sessions.Finish(m)
+		b.bot.Edit(c.Message, fmt.Sprintf("Saving *%s*…", escapeMarkdown(task.Title)), tb.ModeMarkdown)
+		b.saveTask(m, task)
+	case "cancel":
+		b.sessions.Finish(m)
+		b.bot.Edit(c.Message, "Task creation cancelled")
+	default:
+		state := StateCreateTask
+		switch c.Data {
+		case "assignee":
+			state = StateTaskAssignee
+		case "deadline":
+			state = StateTaskDeadline
+		case "description":
+			state = StateTaskDescription
+		}
+		b.sessions.Update(m, func(s *Session) {
+			s.State = state
+			s.Data["editing"] = "1"
+		})
+		b.askWizardStep(m, state, c.Message)
+	}
+}
+
+//calendarKeyboard render the month of the given day as a date picker,
+//today is the current day of the chat
+func calendarKeyboard(day, today time.Time) [][]tb.InlineButton {
+	first := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+	keys := [][]tb.InlineButton{
+		{
+			dataButton(wizardDeadlineBtn, "Today", today.Format(wizardDateLayout)),
+			dataButton(wizardDeadlineBtn, "Tomorrow", today.AddDate(0, 0, 1).Format(wizardDateLayout)),
+			dataButton(wizardDeadlineBtn, "Next week", today.AddDate(0, 0, 7).Format(wizardDateLayout)),
+		},
+		{dataButton(wizardIgnoreBtn, first.Format("January 2006"), wizardNone)},
+	}
+	header := []tb.InlineButton{}
+	for _, name := range []string{"Mo", "Tu", "We", "Th", "Fr", "Sa", "Su"} {
+		header = append(header, dataButton(wizardIgnoreBtn, name, wizardNone))
+	}
+	keys = append(keys, header)
+
+	// weeks start on monday
+	offset := (int(first.Weekday()) + 6) % 7
+	row := []tb.InlineButton{}
+	for i := 0; i < offset; i++ {
+		row = append(row, dataButton(wizardIgnoreBtn, " ", wizardNone))
+	}
+	for date := first; date.Month() == first.Month(); date = date.AddDate(0, 0, 1) {
+		row = append(row, dataButton(wizardDeadlineBtn, fmt.Sprintf("%d", date.Day()), date.Format(wizardDateLayout)))
+		if len(row) == 7 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		for len(row) < 7 {
+			row = append(row, dataButton(wizardIgnoreBtn, " ", wizardNone))
+		}
+		keys = append(keys, row)
+	}
+	keys = append(keys, []tb.InlineButton{
+		dataButton(wizardCalendarBtn, "«", first.AddDate(0, -1, 0).Format("2006-01")),
+		dataButton(wizardDeadlineBtn, "No deadline", wizardNone),
+		dataButton(wizardCalendarBtn, "»", first.AddDate(0, 1, 0).Format("2006-01")),
+	})
+	return keys
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// legacyInitialStatuses are statuses written before workflows existed,
+// they are read as the first state of the workflow
+var legacyInitialStatuses = []string{"", "init", "not_start"}
+
+var errBadWorkflow = errors.New("describe each allowed move as from>to and mark closed states with *, eg: /workflow todo>doing doing>done* done>doing")
+
+// statusSetBtn moves a task to a state, its data is "<task id>:<state>"
+var statusSetBtn = tb.InlineButton{Unique: "status_set"}
+
+//Workflow is the states a task of a project goes through
+//The first state is given to new tasks.
+type Workflow struct {
+	States      []string
+	Closed      []string
+	Transitions map[string][]string
+}
+
+//defaultWorkflow is used by projects that did not define their own
+func defaultWorkflow() Workflow {
+	return Workflow{
+		States: []string{"todo", "doing", "done"},
+		Closed: []string{"done"},
+		Transitions: map[string][]string{
+			"todo":  {"doing", "done"},
+			"doing": {"todo", "done"},
+			"done":  {"doing"},
+		},
+	}
+}
+
+//parseWorkflow read a workflow written as moves like "todo>doing doing>done*"
+//States appear in the order they are first written, * marks closed states.
+func parseWorkflow(spec string) (Workflow, error) {
+	workflow := Workflow{Transitions: map[string][]string{}}
+	addState := func(name string) (string, error) {
+		closed := strings.HasSuffix(name, "*")
+		name = strings.TrimSuffix(name, "*")
+		if name == "" || strings.ContainsAny(name, ":*| ") {
+			return "", errBadWorkflow
+		}
+		if !workflow.Has(name) {
+			workflow.States = append(workflow.States, name)
+		}
+		if closed && !workflow.IsClosed(name) {
+			workflow.Closed = append(workflow.Closed, name)
+		}
+		return name, nil
+	}
+	for _, move := range strings.FieldsFunc(strings.ToLower(spec), func(r rune) bool {
+		return r == ' ' || r == ','
+	}) {
+		ends := strings.Split(move, ">")
+		if len(ends) != 2 {
+			return workflow, errBadWorkflow
+		}
+		from, err := addState(ends[0])
+		if err != nil {
+			return workflow, err
+		}
+		to, err := addState(ends[1])
+		if err != nil {
+			return workflow, err
+		}
+		if !workflow.CanMove(from, to) && from != to {
+			workflow.Transitions[from] = append(workflow.Transitions[from], to)
+		}
+	}
+	if len(workflow.States) == 0 || len(workflow.Closed) == 0 {
+		return workflow, errBadWorkflow
+	}
+	return workflow, nil
+}
+
+//Initial return the state of new tasks
+func (w Workflow) Initial() string {
+	return w.States[0]
+}
+
+//Has report whether a state belongs to the workflow
+func (w Workflow) Has(state string) bool {
+	return contains(w.States, state)
+}
+
+//IsClosed report whether tasks in a state are finished
+func (w Workflow) IsClosed(state string) bool {
+	return contains(w.Closed, w.Normalize(state))
+}
+
+//Normalize map statuses from before workflows to the initial state
+func (w Workflow) Normalize(status string) string {
+	if !w.Has(status) && contains(legacyInitialStatuses, status) {
+		return w.Initial()
+	}
+	return status
+}
+
+//CanMove report whether a task may go from one state to another
+func (w Workflow) CanMove(from, to string) bool {
+	return contains(w.Transitions[w.Normalize(from)], to)
+}
+
+//Next return the states a task may move to from its state
+//A status outside the workflow may move to any state to recover.
+func (w Workflow) Next(from string) []string {
+	from = w.Normalize(from)
+	if !w.Has(from) {
+		return w.States
+	}
+	return w.Transitions[from]
+}
+
+//DoneState return the first closed state
+func (w Workflow) DoneState() string {
+	return w.Closed[0]
+}
+
+//Statuses return the stored statuses meaning a state, including legacy ones
+func (w Workflow) Statuses(state string) []string {
+	if state == w.Initial() {
+		return append([]string{state}, legacyInitialStatuses...)
+	}
+	return []string{state}
+}
+
+//String render the workflow in the syntax parseWorkflow reads
+func (w Workflow) String() string {
+	moves := []string{}
+	mark := func(state string) string {
+		if w.IsClosed(state) {
+			return state + "*"
+		}
+		return state
+	}
+	for _, from := range w.States {
+		for _, to := range w.Transitions[from] {
+			moves = append(moves, mark(from)+">"+mark(to))
+		}
+	}
+	return strings.Join(moves, " ")
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//moveTo change the status of a task and remember when and by whom
+func (t *TaskDB) moveTo(status, by string, at time.Time) {
+	t.Status = status
+	t.History = append(t.History, StatusChange{Status: status, At: at, By: by})
+}
+
+//workflowOf return the workflow of a project
+func workflowOf(storage *TaskStorage, projectID int) Workflow {
+	project, err := storage.GetProject(projectID)
+	if err != nil || len(project.Workflow.States) == 0 {
+		return defaultWorkflow()
+	}
+	return project.Workflow
+}
+
+//isClosed report whether a task is finished in the workflow of its project
+func isClosed(storage *TaskStorage, task TaskDB) bool {
+	return workflowOf(storage, task.ProjectID).IsClosed(task.Status)
+}
+
+func (b Bot) handleWorkflow(m *tb.Message) {
+	defaultProject, _ := b.storage.GetDefaultProject(m.Chat.ID)
+	project, err := b.storage.GetProject(defaultProject.ProjectID)
+	if err != nil {
+		b.bot.Reply(m, "Set a default project first with /set_default_project")
+		return
+	}
+	spec := strings.TrimSpace(m.Payload)
+	if spec == "" {
+		workflow := workflowOf(b.storage, project.ID)
+		b.bot.Reply(m, fmt.Sprintf("Workflow of *%s*: `%s`\nNew tasks start as *%s*.",
+			project.Title, workflow, workflow.Initial()), tb.ModeMarkdown)
+		return
+	}
+	if spec == "default" {
+		project.Workflow = Workflow{}
+	} else {
+		workflow, err := parseWorkflow(spec)
+		if err != nil {
+			b.bot.Reply(m, fmt.Sprintf("Cannot set workflow: %s", err.Error()))
+			return
+		}
+		project.Workflow = workflow
+	}
+	if err := b.storage.UpdateProject(project); err != nil {
+		b.bot.Reply(m, fmt.Sprintf("Cannot set workflow: %s", err.Error()))
+		return
+	}
+	b.bot.Reply(m, fmt.Sprintf("Workflow of *%s* now is `%s`", project.Title, workflowOf(b.storage, project.ID)),
+		tb.ModeMarkdown)
+}
+
+//statusKeyboard offer the states a task can move to
+func statusKeyboard(task TaskDB, workflow Workflow) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	for _, state := range workflow.Next(task.Status) {
+		data := fmt.Sprintf("%d:%s", task.ID, state)
+		keys = append(keys, []tb.InlineButton{dataButton(statusSetBtn, state, data)})
+	}
+	return keys
+}
+
+//askStatus send the valid next states of a task as buttons
+func (b Bot) askStatus(task TaskDB, m *tb.Message) {
+	workflow := workflowOf(b.storage, task.ProjectID)
+	keys := statusKeyboard(task, workflow)
+	if len(keys) == 0 {
+		b.bot.Reply(m, fmt.Sprintf("Task *%s* cannot leave *%s*", task.Title, workflow.Normalize(task.Status)), tb.ModeMarkdown)
+		return
+	}
+	b.replyAbout(task, m, fmt.Sprintf("Move *%s* from *%s* to:", task.Title, workflow.Normalize(task.Status)), &tb.SendOptions{
+		ParseMode:   tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keys},
+	})
+}
+
+//handleStatusButton move a task to the state picked
+func (b Bot) handleStatusButton(c *tb.Callback) {
+	parts := strings.SplitN(c.Data, ":", 2)
+	if len(parts) != 2 {
+		b.bot.Respond(c, &tb.CallbackResponse{})
+		return
+	}
+	taskID, _ := strconv.Atoi(parts[0])
+	task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID)
+	if err != nil {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: "This task does not exist anymore"})
+		return
+	}
+	if ok, refusal := b.allowed(c.Message.Chat, c.Sender, task.ProjectID, permEditTasks); !ok {
+		b.bot.Respond(c, &tb.CallbackResponse{Text: refusal, ShowAlert: true})
+		return
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{})
+	b.setStatus(taskID, parts[1], callbackMessage(c))
+	// the buttons are used, the message shows the task as it is now
+	if task, err := b.storage.GetChatTask(c.Message.Chat.ID, taskID); err == nil {
+		b.bot.Edit(c.Message, b.taskCardText(task, b.locale(c.Message.Chat.ID)), tb.ModeMarkdown)
+	}
+}